@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xuanlv2002/miniMem0/config"
+	"github.com/xuanlv2002/miniMem0/memory"
+	"github.com/xuanlv2002/miniMem0/server"
+)
+
+/*
+	miniMem0 独立部署服务
+	通过 HTTP 接口对外提供记忆能力 非 Go 语言的服务也可以直接接入
+*/
+
+func main() {
+	configPath := flag.String("config", "config/local.yaml", "配置文件路径")
+	addr := flag.String("addr", "", "监听地址 覆盖配置文件中的 SERVER.ADDR")
+	flag.Parse()
+
+	conf, err := config.LoadConfig(*configPath)
+	if err != nil {
+		logrus.Fatalf("load config error: %v", err)
+	}
+
+	serverConfig := conf.GetServerConfig()
+	if serverConfig == nil {
+		serverConfig = &config.ServerConfig{}
+	}
+	if *addr != "" {
+		serverConfig.Addr = *addr
+	}
+	if serverConfig.Addr == "" {
+		serverConfig.Addr = ":8080"
+	}
+	if serverConfig.ShutdownTimeout <= 0 {
+		serverConfig.ShutdownTimeout = 30
+	}
+
+	memSys, err := memory.NewMemorySystem(conf)
+	if err != nil {
+		logrus.Fatalf("init memory system error: %v", err)
+	}
+
+	handler := server.NewServer(memSys)
+	httpServer := &http.Server{
+		Addr:    serverConfig.Addr,
+		Handler: handler,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		logrus.Infof("minimem0 server listening on %s", serverConfig.Addr)
+		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logrus.Fatalf("listen error: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	logrus.Info("shutting down minimem0 server")
+
+	// 先停止接收新请求 再等待后台的记忆更新完成
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(serverConfig.ShutdownTimeout)*time.Second)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		logrus.Errorf("shutdown error: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		handler.WaitDone()
+		close(done)
+	}()
+	select {
+	case <-done:
+		logrus.Info("all memory updates finished")
+	case <-shutdownCtx.Done():
+		logrus.Warn("timeout waiting for memory updates")
+	}
+	// 停止后台任务 超时仍在执行的任务被中断 被中断和未执行的任务在下次启动时继续
+	memSys.Close()
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/sashabaranov/go-openai"
 	"github.com/sirupsen/logrus"
@@ -18,28 +19,98 @@ type LLMConfig struct {
 	BaseURL     string  `mapstructure:"BASE_URL"`
 	APIKey      string  `mapstructure:"API_KEY"`
 	Temperature float32 `mapstructure:"TEMPERATURE"`
+	// 结构化输出方式 json_schema / json_object / tool / none(默认 只依靠提示词约束)
+	ResponseFormat string `mapstructure:"RESPONSE_FORMAT"`
 }
 
-// LLMConfig 定义 LLM 服务的配置结构
+// EmbeddingConfig 定义 Embedding 服务的配置结构
 type EmbeddingConfig struct {
+	Provider   string                `mapstructure:"PROVIDER"` // openai(默认): OpenAI兼容接口 local: 离线哈希向量
 	Model      openai.EmbeddingModel `mapstructure:"MODEL"`
 	BaseURL    string                `mapstructure:"BASE_URL"`
 	APIKey     string                `mapstructure:"API_KEY"`
 	Dimensions int                   `mapstructure:"DIMENSIONS"`
+	BatchSize  int                   `mapstructure:"BATCH_SIZE"` // 单次请求的最大文本数量
+	Cache      bool                  `mapstructure:"CACHE"`      // 是否开启向量缓存
+	CacheSize  int                   `mapstructure:"CACHE_SIZE"` // 最大缓存数量 0表示不限制
 }
 
 // VectorConfig 定义向量数据库的配置结构
 type VectorConfig struct {
-	Path                string  `mapstructure:"PATH"`
-	Collection          string  `mapstructure:"COLLECTION_NAME"`
-	TopK                int     `mapstructure:"TOPK"`
-	SimilarityThreshold float32 `mapstructure:"SIMILARITY_THRESHOLD"`
+	Path                string        `mapstructure:"PATH"`
+	Collection          string        `mapstructure:"COLLECTION_NAME"`
+	TopK                int           `mapstructure:"TOPK"`
+	SimilarityThreshold float32       `mapstructure:"SIMILARITY_THRESHOLD"`
+	RecencyHalfLife     time.Duration `mapstructure:"RECENCY_HALF_LIFE"` // 时间衰减的半衰期 为0时只按相似度排序
+	RecencyWeight       float32       `mapstructure:"RECENCY_WEIGHT"`    // 时间衰减在综合得分中的权重 0~1
+	KeywordWeight       float32       `mapstructure:"KEYWORD_WEIGHT"`    // 关键词检索在融合排序中的权重 0~1 为0时只使用向量检索
+	RRFK                int           `mapstructure:"RRF_K"`             // 倒数排序融合的常数 默认60
 }
 
 type SqlConfig struct {
 	Path string `mapstructure:"PATH"`
 }
 
+// PromptBudgetConfig 定义提示词各部分的token预算 0表示不限制
+type PromptBudgetConfig struct {
+	Total   int `mapstructure:"TOTAL"`   // 提示词总预算
+	Summary int `mapstructure:"SUMMARY"` // 上下文摘要记忆预算
+	Long    int `mapstructure:"LONG"`    // 长期记忆预算
+	Short   int `mapstructure:"SHORT"`   // 短期记忆预算
+	Input   int `mapstructure:"INPUT"`   // 用户输入预算
+}
+
+// PromptConfig 定义提示词模板的配置
+type PromptConfig struct {
+	Language    string `mapstructure:"LANGUAGE"`     // 内置模板语言 zh(默认)/en/auto
+	TemplateDir string `mapstructure:"TEMPLATE_DIR"` // 自定义模板目录 目录下同名的.tmpl文件会覆盖内置模板
+}
+
+// ServerConfig 定义HTTP服务的配置结构
+type ServerConfig struct {
+	Addr            string `mapstructure:"ADDR"`             // 监听地址
+	ShutdownTimeout int    `mapstructure:"SHUTDOWN_TIMEOUT"` // 优雅退出等待时间(秒)
+}
+
+// JobConfig 定义后台记忆任务队列的配置结构
+type JobConfig struct {
+	Workers       int `mapstructure:"WORKERS"`         // 并发执行任务的数量
+	MaxAttempts   int `mapstructure:"MAX_ATTEMPTS"`    // 最大尝试次数 超过后任务标记为失败
+	RetryDelay    int `mapstructure:"RETRY_DELAY"`     // 首次重试的等待时间(秒) 之后每次翻倍
+	MaxRetryDelay int `mapstructure:"MAX_RETRY_DELAY"` // 重试等待时间的上限(秒)
+}
+
+// TimeoutConfig 定义各阶段的超时时间 0表示不限制 支持 "500ms" "30s" "2m" 等格式
+type TimeoutConfig struct {
+	Input      time.Duration `mapstructure:"INPUT"`      // 处理一次用户输入(检索记忆)的总时间
+	Search     time.Duration `mapstructure:"SEARCH"`     // 一次向量检索(含向量化请求)
+	LLM        time.Duration `mapstructure:"LLM"`        // 一次大模型请求
+	Summary    time.Duration `mapstructure:"SUMMARY"`    // 一次上下文总结的总时间
+	Extraction time.Duration `mapstructure:"EXTRACTION"` // 一次长期记忆抽取的总时间
+}
+
+// RerankConfig 定义长期记忆检索结果重排序的配置
+type RerankConfig struct {
+	Provider string  `mapstructure:"PROVIDER"`  // 为空时不重排序 llm: 使用对话模型打分 http: 调用 /rerank 接口(Jina Cohere 兼容)
+	Model    string  `mapstructure:"MODEL"`     // http 重排序模型
+	BaseURL  string  `mapstructure:"BASE_URL"`  // http 重排序服务地址
+	APIKey   string  `mapstructure:"API_KEY"`   // http 重排序服务的密钥
+	FinalK   int     `mapstructure:"FINAL_K"`   // 重排序后保留的数量 0表示保留全部
+	MinScore float32 `mapstructure:"MIN_SCORE"` // 重排序得分(0~1)的最小阈值
+}
+
+// QueryRewriteConfig 定义检索长期记忆前改写查询的配置
+type QueryRewriteConfig struct {
+	Enable     bool `mapstructure:"ENABLE"`      // 开启后结合短期记忆和上下文摘要 把用户输入改写为独立的检索语句
+	MaxQueries int  `mapstructure:"MAX_QUERIES"` // 改写后最多使用的检索语句数量 0表示不限制
+}
+
+// GraphMemoryConfig 定义关系记忆的配置
+type GraphMemoryConfig struct {
+	Enable       bool `mapstructure:"ENABLE"`        // 开启后抽取长期记忆时同时抽取实体和关系 检索时展开输入中提到的实体
+	MaxRelations int  `mapstructure:"MAX_RELATIONS"` // 一次检索返回的最大关系数量 0表示不限制
+}
+
 /* 记忆层配置 */
 // MemoryContextConfig 定义记忆上下文的配置
 type ContextMemoryConfig struct {
@@ -48,7 +119,11 @@ type ContextMemoryConfig struct {
 
 // LongMemoryConfig 定义长记忆的配置
 type LongMemoryConfig struct {
-	LongGap int `mapstructure:"LONG_GAP"`
+	LongGap        int           `mapstructure:"LONG_GAP"`
+	ParseRetry     int           `mapstructure:"PARSE_RETRY"`           // 模型输出无法解析时 带上错误信息重试的次数
+	MaxMemories    int           `mapstructure:"MAX_MEMORIES_PER_USER"` // 每个用户最多保留的长期记忆数量 0表示不限制
+	Eviction       string        `mapstructure:"EVICTION"`              // 超出数量时淘汰记忆的方式 delete: 删除 archive: 移入归档集合
+	ForgetHalfLife time.Duration `mapstructure:"FORGET_HALF_LIFE"`      // 计算记忆价值时 距最近使用时间的半衰期 为0时不考虑新旧
 }
 
 // ShortMemoryConfig 定义短记忆的配置
@@ -66,6 +141,14 @@ type Config struct {
 	MemoryContextConfig *ContextMemoryConfig `mapstructure:"CONTEXT_MEMORY"`
 	LongMemoryConfig    *LongMemoryConfig    `mapstructure:"LONG_MEMORY"`
 	ShortMemoryConfig   *ShortMemoryConfig   `mapstructure:"SHORT_MEMORY"`
+	ServerConfig        *ServerConfig        `mapstructure:"SERVER"`
+	PromptBudgetConfig  *PromptBudgetConfig  `mapstructure:"PROMPT_BUDGET"`
+	PromptConfig        *PromptConfig        `mapstructure:"PROMPT"`
+	JobConfig           *JobConfig           `mapstructure:"JOB"`
+	TimeoutConfig       *TimeoutConfig       `mapstructure:"TIMEOUT"`
+	RerankConfig        *RerankConfig        `mapstructure:"RERANK"`
+	QueryRewriteConfig  *QueryRewriteConfig  `mapstructure:"QUERY_REWRITE"`
+	GraphMemoryConfig   *GraphMemoryConfig   `mapstructure:"GRAPH_MEMORY"`
 }
 
 func fileExists(filePath string) bool {
@@ -139,6 +222,65 @@ func (c *Config) GetLongMemoryConfig() *LongMemoryConfig {
 func (c *Config) GetShortMemoryConfig() *ShortMemoryConfig {
 	return c.ShortMemoryConfig
 }
+
+// GetServerConfig 获取 Server 配置
+func (c *Config) GetServerConfig() *ServerConfig {
+	return c.ServerConfig
+}
+
+// GetJobConfig 获取 Job 配置 未配置时返回空配置 使用默认值
+func (c *Config) GetJobConfig() *JobConfig {
+	if c.JobConfig == nil {
+		return &JobConfig{}
+	}
+	return c.JobConfig
+}
+
+// GetTimeoutConfig 获取 Timeout 配置 未配置时返回不限制的超时
+func (c *Config) GetTimeoutConfig() *TimeoutConfig {
+	if c.TimeoutConfig == nil {
+		return &TimeoutConfig{}
+	}
+	return c.TimeoutConfig
+}
+
+// GetRerankConfig 获取 Rerank 配置 未配置时返回不重排序的配置
+func (c *Config) GetRerankConfig() *RerankConfig {
+	if c.RerankConfig == nil {
+		return &RerankConfig{}
+	}
+	return c.RerankConfig
+}
+
+// GetQueryRewriteConfig 获取 QueryRewrite 配置 未配置时返回不改写的配置
+func (c *Config) GetQueryRewriteConfig() *QueryRewriteConfig {
+	if c.QueryRewriteConfig == nil {
+		return &QueryRewriteConfig{}
+	}
+	return c.QueryRewriteConfig
+}
+
+// GetGraphMemoryConfig 获取 GraphMemory 配置 未配置时返回不开启关系记忆的配置
+func (c *Config) GetGraphMemoryConfig() *GraphMemoryConfig {
+	if c.GraphMemoryConfig == nil {
+		return &GraphMemoryConfig{}
+	}
+	return c.GraphMemoryConfig
+}
+
+// GetPromptBudgetConfig 获取 PromptBudget 配置 未配置时返回不限制的预算
+func (c *Config) GetPromptBudgetConfig() *PromptBudgetConfig {
+	if c.PromptBudgetConfig == nil {
+		return &PromptBudgetConfig{}
+	}
+	return c.PromptBudgetConfig
+}
+
+// GetPromptConfig 获取 Prompt 配置
+func (c *Config) GetPromptConfig() *PromptConfig {
+	return c.PromptConfig
+}
+
 func (c *Config) String() string {
 	var sb strings.Builder
 
@@ -150,16 +292,21 @@ func (c *Config) String() string {
 		sb.WriteString(fmt.Sprintf("    BaseURL: %s\n", c.ChatConfig.BaseURL))
 		sb.WriteString("    APIKey: [REDACTED]\n")
 		sb.WriteString(fmt.Sprintf("    Temperature: %.2f\n", c.ChatConfig.Temperature))
+		sb.WriteString(fmt.Sprintf("    ResponseFormat: %s\n", c.ChatConfig.ResponseFormat))
 	} else {
 		sb.WriteString("  LLM Configuration: nil\n")
 	}
 
 	if c.EmbeddingConfig != nil {
 		sb.WriteString("  Embedding Configuration:\n")
+		sb.WriteString(fmt.Sprintf("    Provider: %s\n", c.EmbeddingConfig.Provider))
 		sb.WriteString(fmt.Sprintf("    Model: %s\n", c.EmbeddingConfig.Model))
 		sb.WriteString(fmt.Sprintf("    BaseURL: %s\n", c.EmbeddingConfig.BaseURL))
 		sb.WriteString("    APIKey: [REDACTED]\n")
 		sb.WriteString(fmt.Sprintf("    Dimensions: %d\n", c.EmbeddingConfig.Dimensions))
+		sb.WriteString(fmt.Sprintf("    BatchSize: %d\n", c.EmbeddingConfig.BatchSize))
+		sb.WriteString(fmt.Sprintf("    Cache: %v\n", c.EmbeddingConfig.Cache))
+		sb.WriteString(fmt.Sprintf("    CacheSize: %d\n", c.EmbeddingConfig.CacheSize))
 	} else {
 		sb.WriteString("  Embedding Configuration: nil\n")
 	}
@@ -170,6 +317,10 @@ func (c *Config) String() string {
 		sb.WriteString(fmt.Sprintf("    Collection: %s\n", c.VectorConfig.Collection))
 		sb.WriteString(fmt.Sprintf("    MaxTopK: %d\n", c.VectorConfig.TopK))
 		sb.WriteString(fmt.Sprintf("    SimilarityThreshold: %.2f\n", c.VectorConfig.SimilarityThreshold))
+		sb.WriteString(fmt.Sprintf("    RecencyHalfLife: %s\n", c.VectorConfig.RecencyHalfLife))
+		sb.WriteString(fmt.Sprintf("    RecencyWeight: %.2f\n", c.VectorConfig.RecencyWeight))
+		sb.WriteString(fmt.Sprintf("    KeywordWeight: %.2f\n", c.VectorConfig.KeywordWeight))
+		sb.WriteString(fmt.Sprintf("    RRFK: %d\n", c.VectorConfig.RRFK))
 	} else {
 		sb.WriteString("  Vector Database Configuration: nil\n")
 	}
@@ -192,6 +343,10 @@ func (c *Config) String() string {
 	if c.LongMemoryConfig != nil {
 		sb.WriteString("  Long Memory Configuration:\n")
 		sb.WriteString(fmt.Sprintf("    LongGap: %d\n", c.LongMemoryConfig.LongGap))
+		sb.WriteString(fmt.Sprintf("    ParseRetry: %d\n", c.LongMemoryConfig.ParseRetry))
+		sb.WriteString(fmt.Sprintf("    MaxMemories: %d\n", c.LongMemoryConfig.MaxMemories))
+		sb.WriteString(fmt.Sprintf("    Eviction: %s\n", c.LongMemoryConfig.Eviction))
+		sb.WriteString(fmt.Sprintf("    ForgetHalfLife: %s\n", c.LongMemoryConfig.ForgetHalfLife))
 	} else {
 		sb.WriteString("  Long Memory Configuration: nil\n")
 	}
@@ -204,5 +359,81 @@ func (c *Config) String() string {
 		sb.WriteString("  Short Memory Configuration: nil\n")
 	}
 
+	if c.PromptBudgetConfig != nil {
+		sb.WriteString("  Prompt Budget Configuration:\n")
+		sb.WriteString(fmt.Sprintf("    Total: %d\n", c.PromptBudgetConfig.Total))
+		sb.WriteString(fmt.Sprintf("    Summary: %d\n", c.PromptBudgetConfig.Summary))
+		sb.WriteString(fmt.Sprintf("    Long: %d\n", c.PromptBudgetConfig.Long))
+		sb.WriteString(fmt.Sprintf("    Short: %d\n", c.PromptBudgetConfig.Short))
+		sb.WriteString(fmt.Sprintf("    Input: %d\n", c.PromptBudgetConfig.Input))
+	} else {
+		sb.WriteString("  Prompt Budget Configuration: nil\n")
+	}
+
+	if c.PromptConfig != nil {
+		sb.WriteString("  Prompt Configuration:\n")
+		sb.WriteString(fmt.Sprintf("    Language: %s\n", c.PromptConfig.Language))
+		sb.WriteString(fmt.Sprintf("    TemplateDir: %s\n", c.PromptConfig.TemplateDir))
+	} else {
+		sb.WriteString("  Prompt Configuration: nil\n")
+	}
+
+	if c.ServerConfig != nil {
+		sb.WriteString("  Server Configuration:\n")
+		sb.WriteString(fmt.Sprintf("    Addr: %s\n", c.ServerConfig.Addr))
+		sb.WriteString(fmt.Sprintf("    ShutdownTimeout: %d\n", c.ServerConfig.ShutdownTimeout))
+	} else {
+		sb.WriteString("  Server Configuration: nil\n")
+	}
+
+	if c.JobConfig != nil {
+		sb.WriteString("  Job Configuration:\n")
+		sb.WriteString(fmt.Sprintf("    Workers: %d\n", c.JobConfig.Workers))
+		sb.WriteString(fmt.Sprintf("    MaxAttempts: %d\n", c.JobConfig.MaxAttempts))
+		sb.WriteString(fmt.Sprintf("    RetryDelay: %d\n", c.JobConfig.RetryDelay))
+		sb.WriteString(fmt.Sprintf("    MaxRetryDelay: %d\n", c.JobConfig.MaxRetryDelay))
+	} else {
+		sb.WriteString("  Job Configuration: nil\n")
+	}
+
+	if c.TimeoutConfig != nil {
+		sb.WriteString("  Timeout Configuration:\n")
+		sb.WriteString(fmt.Sprintf("    Input: %s\n", c.TimeoutConfig.Input))
+		sb.WriteString(fmt.Sprintf("    Search: %s\n", c.TimeoutConfig.Search))
+		sb.WriteString(fmt.Sprintf("    LLM: %s\n", c.TimeoutConfig.LLM))
+		sb.WriteString(fmt.Sprintf("    Summary: %s\n", c.TimeoutConfig.Summary))
+		sb.WriteString(fmt.Sprintf("    Extraction: %s\n", c.TimeoutConfig.Extraction))
+	} else {
+		sb.WriteString("  Timeout Configuration: nil\n")
+	}
+
+	if c.RerankConfig != nil {
+		sb.WriteString("  Rerank Configuration:\n")
+		sb.WriteString(fmt.Sprintf("    Provider: %s\n", c.RerankConfig.Provider))
+		sb.WriteString(fmt.Sprintf("    Model: %s\n", c.RerankConfig.Model))
+		sb.WriteString(fmt.Sprintf("    BaseURL: %s\n", c.RerankConfig.BaseURL))
+		sb.WriteString("    APIKey: [REDACTED]\n")
+		sb.WriteString(fmt.Sprintf("    FinalK: %d\n", c.RerankConfig.FinalK))
+		sb.WriteString(fmt.Sprintf("    MinScore: %.2f\n", c.RerankConfig.MinScore))
+	} else {
+		sb.WriteString("  Rerank Configuration: nil\n")
+	}
+
+	if c.QueryRewriteConfig != nil {
+		sb.WriteString("  Query Rewrite Configuration:\n")
+		sb.WriteString(fmt.Sprintf("    Enable: %t\n", c.QueryRewriteConfig.Enable))
+		sb.WriteString(fmt.Sprintf("    MaxQueries: %d\n", c.QueryRewriteConfig.MaxQueries))
+	} else {
+		sb.WriteString("  Query Rewrite Configuration: nil\n")
+	}
+
+	if c.GraphMemoryConfig != nil {
+		sb.WriteString("  Graph Memory Configuration:\n")
+		sb.WriteString(fmt.Sprintf("    Enable: %t\n", c.GraphMemoryConfig.Enable))
+		sb.WriteString(fmt.Sprintf("    MaxRelations: %d\n", c.GraphMemoryConfig.MaxRelations))
+	} else {
+		sb.WriteString("  Graph Memory Configuration: nil\n")
+	}
+
 	return sb.String()
 }
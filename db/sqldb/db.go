@@ -1,6 +1,7 @@
 package sqldb
 
 import (
+	"context"
 	"sort"
 
 	"github.com/xuanlv2002/miniMem0/config"
@@ -19,22 +20,39 @@ func NewSQL(cfg *config.SqlConfig) (*SqlHandler, error) {
 	if err != nil {
 		return nil, err
 	}
+	// SQLite 同时只允许一个写入 后台任务和请求并发写入时使用单连接串行 避免 database is locked
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	sqlDB.SetMaxOpenConns(1)
 	// Migrate the schema
-	db.AutoMigrate(&model.OriginalMemory{}, &model.ContextMemory{}, &model.LongMemory{})
+	db.AutoMigrate(&model.OriginalMemory{}, &model.ContextMemory{}, &model.LongMemory{}, &model.EmbeddingCache{}, &model.MemoryJob{}, &model.MemoryHistory{}, &model.ExtractionRun{}, &model.Entity{}, &model.Relation{}, &model.MemoryStat{})
 	return &SqlHandler{DB: db}, nil
 }
 
+// 在事务中执行 fn fn 中只能通过 tx 访问数据库 返回错误时回滚
+func (db *SqlHandler) Transaction(fn func(tx *SqlHandler) error) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		return fn(&SqlHandler{DB: tx})
+	})
+}
+
+// 返回使用 ctx 执行查询的处理器 ctx 取消时中断正在进行的查询
+func (db *SqlHandler) WithContext(ctx context.Context) *SqlHandler {
+	return &SqlHandler{DB: db.DB.WithContext(ctx)}
+}
+
 /* 原始记忆处理函数 */
 // 添加一条记忆
 func (db *SqlHandler) AddOriginalMemory(memory *model.OriginalMemory) error {
 	return db.DB.Create(memory).Error
 }
 
-// 获得最近的n条记忆 最新的在前面
-func (db *SqlHandler) GetLastOriginalMemory(count int) ([]model.OriginalMemory, int64, error) {
+// 获得会话最近的n条记忆 按id从小到大排序
+func (db *SqlHandler) GetLastOriginalMemory(userID, sessionID string, count int) ([]model.OriginalMemory, int64, error) {
 	var ret []model.OriginalMemory
-	var retCount int64
-	err := db.DB.Order("id desc").Limit(count).Find(&ret).Count(&retCount).Error
+	err := db.DB.Where("user_id = ? AND session_id = ?", userID, sessionID).Order("id desc").Limit(count).Find(&ret).Error
 	if err != nil {
 		return nil, 0, err
 	}
@@ -43,29 +61,30 @@ func (db *SqlHandler) GetLastOriginalMemory(count int) ([]model.OriginalMemory,
 		return ret[i].ID < ret[j].ID
 	})
 
-	return ret, retCount, nil
+	return ret, int64(len(ret)), nil
 }
 
-// 获得所有的记忆
-func (db *SqlHandler) GetTotalOriginalMemory() ([]model.OriginalMemory, int64, error) {
+// 获得会话所有的记忆
+func (db *SqlHandler) GetTotalOriginalMemory(userID, sessionID string) ([]model.OriginalMemory, int64, error) {
 	var ret []model.OriginalMemory
 	// 获得所有数据 没有数据返回空
-	var count int64
-	err := db.DB.Find(&ret).Count(&count).Error
+	err := db.DB.Where("user_id = ? AND session_id = ?", userID, sessionID).Order("id asc").Find(&ret).Error
 	if err != nil {
 		return nil, 0, err
 	}
-	return ret, count, nil
+	return ret, int64(len(ret)), nil
 }
 
 /* 上下文记忆处理函数 */
-// 获得上下文记忆
-func (db *SqlHandler) GetLastContextMemory() (*model.ContextMemory, error) {
+// 获得会话的上下文记忆 不存在时返回一个绑定了会话的空记忆
+func (db *SqlHandler) GetLastContextMemory(userID, sessionID string) (*model.ContextMemory, error) {
 	var ret model.ContextMemory
-	err := db.DB.Last(&ret).Error
+	err := db.DB.Where("user_id = ? AND session_id = ?", userID, sessionID).Last(&ret).Error
 	if err != nil && err != gorm.ErrRecordNotFound {
 		return nil, err
 	}
+	ret.UserID = userID
+	ret.SessionID = sessionID
 	return &ret, nil
 }
 
@@ -74,10 +93,12 @@ func (db *SqlHandler) SaveContextMemory(memory *model.ContextMemory) error {
 	return db.DB.Save(memory).Error
 }
 
-// 获得未总结的记忆的个数
-func (db *SqlHandler) GetUnSummarizedMemoryCount(lastSummaryID int64) (int64, error) {
+// 获得会话未总结的记忆的个数
+func (db *SqlHandler) GetUnSummarizedMemoryCount(userID, sessionID string, lastSummaryID int64) (int64, error) {
 	var count int64
-	err := db.DB.Model(&model.OriginalMemory{}).Where("id > ?", lastSummaryID).Count(&count).Error
+	err := db.DB.Model(&model.OriginalMemory{}).
+		Where("user_id = ? AND session_id = ? AND id > ?", userID, sessionID, lastSummaryID).
+		Count(&count).Error
 	if err != nil {
 		return 0, err
 	}
@@ -85,12 +106,15 @@ func (db *SqlHandler) GetUnSummarizedMemoryCount(lastSummaryID int64) (int64, er
 }
 
 /* 长期记忆处理函数 */
-func (db *SqlHandler) GetLastLongMemroy() (*model.LongMemory, error) {
+// 获得会话的长期记忆抽取进度 不存在时返回一个绑定了会话的空记录
+func (db *SqlHandler) GetLastLongMemroy(userID, sessionID string) (*model.LongMemory, error) {
 	var ret model.LongMemory
-	err := db.DB.Last(&ret).Error
+	err := db.DB.Where("user_id = ? AND session_id = ?", userID, sessionID).Last(&ret).Error
 	if err != nil && err != gorm.ErrRecordNotFound {
 		return nil, err
 	}
+	ret.UserID = userID
+	ret.SessionID = sessionID
 	return &ret, nil
 }
 
@@ -98,10 +122,12 @@ func (db *SqlHandler) SaveLongMemoryLastExtractionID(memory *model.LongMemory) e
 	return db.DB.Save(memory).Error
 }
 
-// 获得未总结的记忆的个数
-func (db *SqlHandler) GetUnExtractionMemoryCount(LastExtractionID int64) (int64, error) {
+// 获得会话未抽取的记忆的个数
+func (db *SqlHandler) GetUnExtractionMemoryCount(userID, sessionID string, LastExtractionID int64) (int64, error) {
 	var count int64
-	err := db.DB.Model(&model.OriginalMemory{}).Where("id > ?", LastExtractionID).Count(&count).Error
+	err := db.DB.Model(&model.OriginalMemory{}).
+		Where("user_id = ? AND session_id = ? AND id > ?", userID, sessionID, LastExtractionID).
+		Count(&count).Error
 	if err != nil {
 		return 0, err
 	}
@@ -0,0 +1,85 @@
+package sqldb
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+
+	"github.com/xuanlv2002/miniMem0/model"
+	"gorm.io/gorm/clause"
+)
+
+/* 向量缓存处理函数 */
+// 按文本哈希批量查询缓存的向量
+func (db *SqlHandler) GetEmbeddings(modelName string, dimensions int, hashes []string) (map[string][]float32, error) {
+	var caches []model.EmbeddingCache
+	err := db.DB.Where("model = ? AND dimensions = ? AND hash IN ?", modelName, dimensions, hashes).Find(&caches).Error
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[string][]float32, len(caches))
+	for _, cache := range caches {
+		ret[cache.Hash] = decodeVector(cache.Vector)
+	}
+	return ret, nil
+}
+
+// 批量写入向量缓存 已存在时覆盖
+func (db *SqlHandler) PutEmbeddings(modelName string, dimensions int, vectors map[string][]float32) error {
+	if len(vectors) == 0 {
+		return nil
+	}
+	now := time.Now()
+	caches := make([]model.EmbeddingCache, 0, len(vectors))
+	for hash, vector := range vectors {
+		caches = append(caches, model.EmbeddingCache{
+			Model:      modelName,
+			Dimensions: dimensions,
+			Hash:       hash,
+			Vector:     encodeVector(vector),
+			LastUsedAt: now,
+			CreatedAt:  now,
+		})
+	}
+	return db.DB.Clauses(clause.OnConflict{UpdateAll: true}).Create(&caches).Error
+}
+
+// 刷新缓存的最近使用时间
+func (db *SqlHandler) TouchEmbeddings(modelName string, dimensions int, hashes []string) error {
+	if len(hashes) == 0 {
+		return nil
+	}
+	return db.DB.Model(&model.EmbeddingCache{}).
+		Where("model = ? AND dimensions = ? AND hash IN ?", modelName, dimensions, hashes).
+		Update("last_used_at", time.Now()).Error
+}
+
+// 缓存数量超过 maxSize 时淘汰最久未使用的缓存
+func (db *SqlHandler) EvictEmbeddings(maxSize int) error {
+	var count int64
+	if err := db.DB.Model(&model.EmbeddingCache{}).Count(&count).Error; err != nil {
+		return err
+	}
+	overflow := int(count) - maxSize
+	if overflow <= 0 {
+		return nil
+	}
+	oldest := db.DB.Model(&model.EmbeddingCache{}).Select("rowid").Order("last_used_at asc").Limit(overflow)
+	return db.DB.Where("rowid IN (?)", oldest).Delete(&model.EmbeddingCache{}).Error
+}
+
+func encodeVector(vector []float32) []byte {
+	buf := make([]byte, 4*len(vector))
+	for i, v := range vector {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(v))
+	}
+	return buf
+}
+
+func decodeVector(buf []byte) []float32 {
+	vector := make([]float32, len(buf)/4)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:]))
+	}
+	return vector
+}
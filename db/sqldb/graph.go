@@ -0,0 +1,112 @@
+package sqldb
+
+import (
+	"time"
+
+	"github.com/xuanlv2002/miniMem0/model"
+	"gorm.io/gorm"
+)
+
+/* 关系记忆处理函数 */
+
+// 获得用户的所有实体
+func (db *SqlHandler) GetEntities(userID string) ([]model.Entity, error) {
+	var ret []model.Entity
+	if err := db.DB.Where("user_id = ?", userID).Order("id asc").Find(&ret).Error; err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// 按名称保存实体 已存在时只在类型非空时更新类型
+func (db *SqlHandler) SaveEntity(userID, name, entityType string) (*model.Entity, error) {
+	var ret model.Entity
+	result := db.DB.Where("user_id = ? AND name = ?", userID, name).Limit(1).Find(&ret)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		ret = model.Entity{UserID: userID, Name: name, Type: entityType}
+		if err := db.DB.Create(&ret).Error; err != nil {
+			return nil, err
+		}
+		return &ret, nil
+	}
+	if entityType != "" && entityType != ret.Type {
+		if err := db.DB.Model(&ret).Update("type", entityType).Error; err != nil {
+			return nil, err
+		}
+	}
+	return &ret, nil
+}
+
+// 添加关系 相同的关系已存在时只更新时间 保留最初产生关系的抽取批次
+func (db *SqlHandler) AddRelation(relation *model.Relation) error {
+	var old model.Relation
+	result := db.DB.Where("user_id = ? AND source_id = ? AND target_id = ? AND type = ? AND deleted_at IS NULL",
+		relation.UserID, relation.SourceID, relation.TargetID, relation.Type).Limit(1).Find(&old)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return db.DB.Create(relation).Error
+	}
+	*relation = old
+	return db.DB.Model(relation).Update("updated_at", time.Now()).Error
+}
+
+// 获得与实体相连的关系 limit 为0时不限制 按最近被抽取到的时间排序
+func (db *SqlHandler) GetRelations(userID string, entityIDs []int64, limit int) ([]model.Relation, error) {
+	var ret []model.Relation
+	if len(entityIDs) == 0 {
+		return ret, nil
+	}
+	query := db.DB.Preload("Source").Preload("Target").
+		Where("user_id = ? AND deleted_at IS NULL AND (source_id IN ? OR target_id IN ?)", userID, entityIDs, entityIDs).
+		Order("updated_at desc, id desc")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	if err := query.Find(&ret).Error; err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// 列出用户的所有关系
+func (db *SqlHandler) ListRelations(userID string) ([]model.Relation, error) {
+	var ret []model.Relation
+	err := db.DB.Preload("Source").Preload("Target").Where("user_id = ? AND deleted_at IS NULL", userID).Order("id asc").Find(&ret).Error
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// 删除被一次抽取推翻的关系 保留记录以便撤销抽取时恢复
+func (db *SqlHandler) DeleteRelations(userID string, runID int64, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return db.DB.Model(&model.Relation{}).Where("user_id = ? AND id IN ? AND deleted_at IS NULL", userID, ids).
+		Updates(map[string]any{"deleted_at": time.Now(), "deleted_run_id": runID}).Error
+}
+
+// 撤销一次抽取对关系的修改 删除抽取新增的关系 恢复抽取删除的关系 返回删除和恢复的数量
+func (db *SqlHandler) RevertRunRelations(userID string, runID int64) (deleted, restored int64, err error) {
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("user_id = ? AND extraction_run_id = ?", userID, runID).Delete(&model.Relation{})
+		if result.Error != nil {
+			return result.Error
+		}
+		deleted = result.RowsAffected
+		result = tx.Model(&model.Relation{}).Where("user_id = ? AND deleted_run_id = ?", userID, runID).
+			Updates(map[string]any{"deleted_at": nil, "deleted_run_id": 0})
+		if result.Error != nil {
+			return result.Error
+		}
+		restored = result.RowsAffected
+		return nil
+	})
+	return deleted, restored, err
+}
@@ -0,0 +1,125 @@
+package sqldb
+
+import (
+	"time"
+
+	"github.com/xuanlv2002/miniMem0/model"
+)
+
+/* 长期记忆变更记录处理函数 */
+
+// 添加一条变更记录
+func (db *SqlHandler) AddMemoryHistory(history *model.MemoryHistory) error {
+	return db.DB.Create(history).Error
+}
+
+// 删除一条变更记录 变更没有执行成功时调用
+func (db *SqlHandler) DeleteMemoryHistory(id int64) error {
+	return db.DB.Delete(&model.MemoryHistory{}, id).Error
+}
+
+// 获得用户某条记忆的所有变更记录 按时间从早到晚排序
+func (db *SqlHandler) GetMemoryHistory(userID, memoryID string) ([]model.MemoryHistory, error) {
+	var ret []model.MemoryHistory
+	err := db.DB.Where("user_id = ? AND memory_id = ?", userID, memoryID).Order("id asc").Find(&ret).Error
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// 获得记忆最新的一条变更记录 没有记录时返回nil
+func (db *SqlHandler) GetLastMemoryHistory(userID, memoryID string) (*model.MemoryHistory, error) {
+	var ret model.MemoryHistory
+	result := db.DB.Where("user_id = ? AND memory_id = ?", userID, memoryID).Order("id desc").Limit(1).Find(&ret)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return nil, result.Error
+	}
+	return &ret, nil
+}
+
+// 获得一次抽取对某条记忆的变更记录 没有记录时返回nil 用于重试时跳过已经执行的操作
+func (db *SqlHandler) GetRunMemoryHistory(runID int64, memoryID string) (*model.MemoryHistory, error) {
+	var ret model.MemoryHistory
+	result := db.DB.Where("extraction_run_id = ? AND memory_id = ?", runID, memoryID).Order("id desc").Limit(1).Find(&ret)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return nil, result.Error
+	}
+	return &ret, nil
+}
+
+// 获得一次抽取产生的所有变更记录 按时间从早到晚排序
+func (db *SqlHandler) GetRunHistory(runID int64) ([]model.MemoryHistory, error) {
+	var ret []model.MemoryHistory
+	err := db.DB.Where("extraction_run_id = ?", runID).Order("id asc").Find(&ret).Error
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+/* 长期记忆抽取批次处理函数 */
+
+// 添加一次抽取
+func (db *SqlHandler) AddExtractionRun(run *model.ExtractionRun) error {
+	return db.DB.Create(run).Error
+}
+
+// 获得用户的一次抽取
+func (db *SqlHandler) GetExtractionRun(userID string, runID int64) (*model.ExtractionRun, error) {
+	var ret model.ExtractionRun
+	if err := db.DB.Where("user_id = ?", userID).First(&ret, runID).Error; err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+// 列出用户的抽取 sessionID 为空时不过滤 按时间从晚到早排序
+func (db *SqlHandler) ListExtractionRuns(userID, sessionID string) ([]model.ExtractionRun, error) {
+	var ret []model.ExtractionRun
+	query := db.DB.Where("user_id = ?", userID).Order("id desc")
+	if sessionID != "" {
+		query = query.Where("session_id = ?", sessionID)
+	}
+	if err := query.Find(&ret).Error; err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// 获得会话中从 prevExtractionID 开始 写入未完成且未撤销的抽取 没有时返回nil
+func (db *SqlHandler) GetUnfinishedExtractionRun(userID, sessionID string, prevExtractionID int64) (*model.ExtractionRun, error) {
+	var ret model.ExtractionRun
+	result := db.DB.Where("user_id = ? AND session_id = ? AND prev_extraction_id = ? AND plan != '' AND finished_at IS NULL AND reverted_at IS NULL",
+		userID, sessionID, prevExtractionID).Order("id desc").Limit(1).Find(&ret)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return nil, result.Error
+	}
+	return &ret, nil
+}
+
+// 标记抽取的关系变更已写入
+func (db *SqlHandler) MarkExtractionRunGraphApplied(run *model.ExtractionRun) error {
+	run.GraphApplied = true
+	return db.DB.Model(run).Update("graph_applied", true).Error
+}
+
+// 标记抽取写入完成 并在同一事务中更新会话的抽取进度
+func (db *SqlHandler) FinishExtractionRun(run *model.ExtractionRun, longMemory *model.LongMemory, at time.Time) error {
+	return db.Transaction(func(tx *SqlHandler) error {
+		if err := tx.DB.Model(run).Update("finished_at", at).Error; err != nil {
+			return err
+		}
+		if err := tx.SaveLongMemoryLastExtractionID(longMemory); err != nil {
+			return err
+		}
+		run.FinishedAt = &at
+		return nil
+	})
+}
+
+// 标记抽取已撤销
+func (db *SqlHandler) MarkExtractionRunReverted(run *model.ExtractionRun, at time.Time) error {
+	run.RevertedAt = &at
+	return db.DB.Model(run).Update("reverted_at", at).Error
+}
@@ -0,0 +1,175 @@
+package sqldb
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/xuanlv2002/miniMem0/model"
+	"gorm.io/gorm"
+)
+
+/* 后台记忆任务处理函数 */
+
+// 添加任务 同一会话同类型已有等待中的任务时不重复添加
+// 任务执行时会读取会话最新的进度 因此一个等待中的任务即可覆盖多次触发
+// 合并到等待退避重试的任务时 新的输入不应等待上次失败的退避 执行时间和尝试次数重置
+func (db *SqlHandler) EnqueueJob(jobType, userID, sessionID string) (*model.MemoryJob, error) {
+	var job model.MemoryJob
+	now := time.Now()
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("type = ? AND user_id = ? AND session_id = ? AND status = ?",
+			jobType, userID, sessionID, model.JobStatusPending).Limit(1).Find(&job)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			if !job.NextRunAt.After(now) {
+				return nil
+			}
+			job.NextRunAt = now
+			job.Attempts = 0
+			return tx.Model(&job).Updates(map[string]any{
+				"next_run_at": job.NextRunAt,
+				"attempts":    job.Attempts,
+			}).Error
+		}
+		job = model.MemoryJob{
+			Type:      jobType,
+			UserID:    userID,
+			SessionID: sessionID,
+			Status:    model.JobStatusPending,
+			NextRunAt: now,
+		}
+		return tx.Create(&job).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// 领取一个到期的等待中任务 并标记为执行中 没有任务时返回nil
+// 同一会话同类型的任务同时只会有一个在执行
+func (db *SqlHandler) ClaimJob(now time.Time) (*model.MemoryJob, error) {
+	var job model.MemoryJob
+	found := false
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		running := tx.Session(&gorm.Session{NewDB: true}).Table("memory_jobs AS r").Select("1").
+			Where("r.type = memory_jobs.type AND r.user_id = memory_jobs.user_id AND r.session_id = memory_jobs.session_id AND r.status = ?", model.JobStatusRunning)
+		result := tx.Where("status = ? AND next_run_at <= ? AND NOT EXISTS (?)", model.JobStatusPending, now, running).
+			Order("next_run_at asc, id asc").Limit(1).Find(&job)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		found = true
+		job.Status = model.JobStatusRunning
+		job.Attempts++
+		return tx.Model(&job).Updates(map[string]any{
+			"status":   job.Status,
+			"attempts": job.Attempts,
+		}).Error
+	})
+	if err != nil || !found {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// 任务成功 删除任务
+func (db *SqlHandler) FinishJob(id int64) error {
+	return db.DB.Delete(&model.MemoryJob{}, id).Error
+}
+
+// 执行被中断的任务恢复为等待中 本次执行不计入尝试次数
+func (db *SqlHandler) ReleaseJob(id int64) error {
+	return db.DB.Model(&model.MemoryJob{}).Where("id = ? AND status = ?", id, model.JobStatusRunning).
+		Updates(map[string]any{
+			"status":      model.JobStatusPending,
+			"attempts":    gorm.Expr("MAX(attempts - 1, 0)"),
+			"next_run_at": time.Now(),
+		}).Error
+}
+
+// 任务失败 nextRunAt 为零值时标记为失败 否则等待重试
+func (db *SqlHandler) FailJob(id int64, jobErr error, nextRunAt time.Time) error {
+	updates := map[string]any{
+		"last_error": jobErr.Error(),
+	}
+	if nextRunAt.IsZero() {
+		updates["status"] = model.JobStatusFailed
+	} else {
+		updates["status"] = model.JobStatusPending
+		updates["next_run_at"] = nextRunAt
+	}
+	return db.DB.Model(&model.MemoryJob{}).Where("id = ?", id).Updates(updates).Error
+}
+
+// 重新执行用户失败的任务 尝试次数清零 任务不属于该用户时返回 gorm.ErrRecordNotFound
+func (db *SqlHandler) RetryJob(userID string, id int64) (*model.MemoryJob, error) {
+	var job model.MemoryJob
+	if err := db.DB.Where("user_id = ?", userID).First(&job, id).Error; err != nil {
+		return nil, err
+	}
+	if job.Status != model.JobStatusFailed {
+		return nil, fmt.Errorf("job %d is %s, only failed jobs can be retried", id, job.Status)
+	}
+	job.Status = model.JobStatusPending
+	job.Attempts = 0
+	job.NextRunAt = time.Now()
+	err := db.DB.Model(&job).Updates(map[string]any{
+		"status":      job.Status,
+		"attempts":    job.Attempts,
+		"next_run_at": job.NextRunAt,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// 列出任务 userID 和 status 为空时不过滤
+func (db *SqlHandler) ListJobs(userID, status string) ([]model.MemoryJob, error) {
+	var ret []model.MemoryJob
+	query := db.DB.Order("id asc")
+	if userID != "" {
+		query = query.Where("user_id = ?", userID)
+	}
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if err := query.Find(&ret).Error; err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// 将执行中的任务恢复为等待中 用于程序崩溃重启后继续执行
+func (db *SqlHandler) ResetRunningJobs() (int64, error) {
+	result := db.DB.Model(&model.MemoryJob{}).Where("status = ?", model.JobStatusRunning).
+		Updates(map[string]any{"status": model.JobStatusPending, "next_run_at": time.Now()})
+	return result.RowsAffected, result.Error
+}
+
+// 最早的等待中任务的执行时间 没有等待中的任务时返回零值
+func (db *SqlHandler) NextJobRunAt() (time.Time, error) {
+	var job model.MemoryJob
+	err := db.DB.Where("status = ?", model.JobStatusPending).Order("next_run_at asc").Limit(1).Find(&job).Error
+	if err != nil {
+		return time.Time{}, err
+	}
+	return job.NextRunAt, nil
+}
+
+// 会话中执行中和已到期的等待中任务数量 userID 和 sessionID 为空时统计所有会话
+func (db *SqlHandler) CountActiveJobs(userID, sessionID string, now time.Time) (int64, error) {
+	var count int64
+	query := db.DB.Model(&model.MemoryJob{}).
+		Where("status = ? OR (status = ? AND next_run_at <= ?)", model.JobStatusRunning, model.JobStatusPending, now)
+	if userID != "" {
+		query = query.Where("user_id = ? AND session_id = ?", userID, sessionID)
+	}
+	if err := query.Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
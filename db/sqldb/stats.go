@@ -0,0 +1,50 @@
+package sqldb
+
+import (
+	"time"
+
+	"github.com/xuanlv2002/miniMem0/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+/* 长期记忆使用情况处理函数 */
+
+// 记录记忆被检索返回 使用次数加1 并更新最近使用时间
+func (db *SqlHandler) TouchMemories(userID string, memoryIDs []string, at time.Time) error {
+	if len(memoryIDs) == 0 {
+		return nil
+	}
+	stats := make([]model.MemoryStat, 0, len(memoryIDs))
+	for _, memoryID := range memoryIDs {
+		stats = append(stats, model.MemoryStat{MemoryID: memoryID, UserID: userID, AccessCount: 1, LastAccessedAt: &at})
+	}
+	return db.DB.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "memory_id"}},
+		DoUpdates: clause.Assignments(map[string]any{
+			"access_count":     gorm.Expr("access_count + 1"),
+			"last_accessed_at": at,
+		}),
+	}).Create(&stats).Error
+}
+
+// 获得用户所有记忆的使用情况 键为记忆ID
+func (db *SqlHandler) GetMemoryStats(userID string) (map[string]model.MemoryStat, error) {
+	var stats []model.MemoryStat
+	if err := db.DB.Where("user_id = ?", userID).Find(&stats).Error; err != nil {
+		return nil, err
+	}
+	ret := make(map[string]model.MemoryStat, len(stats))
+	for _, stat := range stats {
+		ret[stat.MemoryID] = stat
+	}
+	return ret, nil
+}
+
+// 删除记忆的使用情况 记忆被删除或淘汰时调用
+func (db *SqlHandler) DeleteMemoryStats(userID string, memoryIDs []string) error {
+	if len(memoryIDs) == 0 {
+		return nil
+	}
+	return db.DB.Where("user_id = ? AND memory_id IN ?", userID, memoryIDs).Delete(&model.MemoryStat{}).Error
+}
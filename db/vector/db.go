@@ -2,6 +2,8 @@ package vector
 
 import (
 	"context"
+	"math"
+	"slices"
 	"time"
 
 	"github.com/xuanlv2002/miniMem0/config"
@@ -9,10 +11,19 @@ import (
 	"github.com/philippgille/chromem-go"
 )
 
+// 系统初始化时写入的记忆ID
+const InitDocumentID = "init"
+
+// 批量向量化函数 返回结果与输入一一对应
+type BatchEmbeddingFunc func(ctx context.Context, texts []string) ([][]float32, error)
+
 type Vector struct {
-	DB         *chromem.DB          // 数据库
-	Collection *chromem.Collection  // 集合
-	Config     *config.VectorConfig // 配置
+	DB                 *chromem.DB           // 数据库
+	Collection         *chromem.Collection   // 集合
+	Config             *config.VectorConfig  // 配置
+	EmbeddingFunc      chromem.EmbeddingFunc // 向量化函数
+	BatchEmbeddingFunc BatchEmbeddingFunc    // 批量向量化函数 可选 设置后批量添加和批量查询只需一次请求
+	Keyword            *KeywordIndex         // 关键词索引 KEYWORD_WEIGHT 为0时为空
 }
 
 func NewVector(cfg *config.VectorConfig, embeddingFunc chromem.EmbeddingFunc) (*Vector, error) {
@@ -27,53 +38,279 @@ func NewVector(cfg *config.VectorConfig, embeddingFunc chromem.EmbeddingFunc) (*
 		return nil, err
 	}
 	collection.AddDocument(context.Background(), chromem.Document{
-		ID:      "init",
+		ID:      InitDocumentID,
 		Content: "正在使用由miniMem0提供的大模型记忆服务系统,本系统由xuanlv2002开发,如果有任何使用问题,欢迎在github上提出issue。地址:https://github.com/xuanlv2002/miniMem0",
 		Metadata: map[string]string{
 			"appearTime": time.Now().Format("2006-01-02 15:04:05"),
 			"about":      "memorySystem",
 		},
 	})
-	return &Vector{
-		DB:         db,
-		Config:     cfg,
-		Collection: collection,
-	}, nil
+	v := &Vector{
+		DB:            db,
+		Config:        cfg,
+		Collection:    collection,
+		EmbeddingFunc: embeddingFunc,
+	}
+	if cfg.KeywordWeight > 0 {
+		// 关键词索引只在内存中 启动时从向量数据库重建
+		docs, err := v.List(context.Background(), nil)
+		if err != nil {
+			return nil, err
+		}
+		v.Keyword = NewKeywordIndex()
+		for _, doc := range docs {
+			v.Keyword.Add(chromem.Document{ID: doc.ID, Content: doc.Content, Metadata: doc.Metadata})
+		}
+	}
+	return v, nil
 }
 
-// 添加向量
+// 添加向量 设置了批量向量化函数时 先一次性为缺少向量的文档生成向量
 func (v *Vector) Add(ctx context.Context, documents []chromem.Document, concurrency int) error {
-	return v.Collection.AddDocuments(ctx, documents, concurrency)
+	if v.BatchEmbeddingFunc != nil {
+		var texts []string
+		var indexes []int
+		for i, doc := range documents {
+			if len(doc.Embedding) == 0 {
+				texts = append(texts, doc.Content)
+				indexes = append(indexes, i)
+			}
+		}
+		if len(texts) > 0 {
+			embeddings, err := v.BatchEmbeddingFunc(ctx, texts)
+			if err != nil {
+				return err
+			}
+			for i, index := range indexes {
+				documents[index].Embedding = embeddings[i]
+			}
+		}
+	}
+	if err := v.Collection.AddDocuments(ctx, documents, concurrency); err != nil {
+		return err
+	}
+	if v.Keyword != nil {
+		v.Keyword.Add(documents...)
+	}
+	return nil
+}
+
+// 按ID获取文档 不存在时返回nil
+func (v *Vector) Get(ctx context.Context, id string) *chromem.Document {
+	doc, err := v.Collection.GetByID(ctx, id)
+	if err != nil {
+		return nil
+	}
+	return &doc
 }
 
 // 删除向量
 func (v *Vector) Delete(ctx context.Context, ids []string) error {
-	return v.Collection.Delete(ctx, nil, nil, ids...)
+	if err := v.Collection.Delete(ctx, nil, nil, ids...); err != nil {
+		return err
+	}
+	if v.Keyword != nil {
+		v.Keyword.Delete(ids...)
+	}
+	return nil
+}
+
+// 查询向量 opts 为过滤条件和返回数量 零值表示使用配置中的默认值
+func (v *Vector) Search(ctx context.Context, search string, opts SearchOptions) ([]Result, error) {
+	embedding, err := v.EmbeddingFunc(ctx, search)
+	if err != nil {
+		return nil, err
+	}
+	return v.searchEmbedding(ctx, search, embedding, opts)
+}
+
+// 批量查询向量 返回结果与输入一一对应 设置了批量向量化函数时只需一次向量化请求
+func (v *Vector) SearchBatch(ctx context.Context, searches []string, opts SearchOptions) ([][]Result, error) {
+	ret := make([][]Result, len(searches))
+	if v.BatchEmbeddingFunc == nil {
+		for i, search := range searches {
+			res, err := v.Search(ctx, search, opts)
+			if err != nil {
+				return nil, err
+			}
+			ret[i] = res
+		}
+		return ret, nil
+	}
+
+	embeddings, err := v.BatchEmbeddingFunc(ctx, searches)
+	if err != nil {
+		return nil, err
+	}
+	for i, embedding := range embeddings {
+		res, err := v.searchEmbedding(ctx, searches[i], embedding, opts)
+		if err != nil {
+			return nil, err
+		}
+		ret[i] = res
+	}
+	return ret, nil
+}
+
+// 使用多条查询语句检索并合并结果 同一条记录保留得分最高的一次
+// 合并后按得分排序 返回数量与单条查询相同
+func (v *Vector) SearchMerged(ctx context.Context, searches []string, opts SearchOptions) ([]Result, error) {
+	if len(searches) == 1 {
+		return v.Search(ctx, searches[0], opts)
+	}
+	results, err := v.SearchBatch(ctx, searches, opts)
+	if err != nil {
+		return nil, err
+	}
+	var merged []Result
+	index := make(map[string]int)
+	for _, res := range results {
+		for _, r := range res {
+			i, ok := index[r.ID]
+			if !ok {
+				index[r.ID] = len(merged)
+				merged = append(merged, r)
+			} else if r.Score > merged[i].Score {
+				merged[i] = r
+			}
+		}
+	}
+	slices.SortStableFunc(merged, func(a, b Result) int {
+		switch {
+		case a.Score > b.Score:
+			return -1
+		case a.Score < b.Score:
+			return 1
+		}
+		return 0
+	})
+	if topK := opts.topK(v.Config.TopK); len(merged) > topK {
+		merged = merged[:topK]
+	}
+	return merged, nil
 }
 
-// 查询向量
-func (v *Vector) Search(ctx context.Context, search string) ([]chromem.Result, error) {
-	topK := v.Config.TopK
-	if v.Collection.Count() < v.Config.TopK {
-		topK = v.Collection.Count()
+// 向量检索 开启关键词检索时与关键词检索的结果融合排序
+func (v *Vector) searchEmbedding(ctx context.Context, search string, embedding []float32, opts SearchOptions) ([]Result, error) {
+	count := v.Collection.Count()
+	topK := opts.topK(v.Config.TopK)
+	halfLife, weight := opts.recency(v.Config.RecencyHalfLife, v.Config.RecencyWeight)
+	// 需要在查询后过滤或重新排序时 先取出所有满足元数据条件的结果
+	nResults := topK
+	if opts.needPostFilter(halfLife > 0) {
+		nResults = count
 	}
-	if topK == 0 {
-		topK = 1
+	if count < nResults {
+		nResults = count
+	}
+	if nResults == 0 {
+		nResults = 1
 	}
 
-	res, err := v.Collection.Query(ctx, search, topK, nil, nil)
+	res, err := v.Collection.QueryEmbedding(ctx, embedding, nResults, opts.Where, opts.whereDocument())
 	if err != nil {
 		return nil, err
 	}
 
 	// 只有相似度大于阈值的会被返回
+	threshold := opts.threshold(v.Config.SimilarityThreshold)
+	ret := opts.filter(res, topK, threshold, halfLife, weight)
+	if v.Keyword == nil {
+		return ret, nil
+	}
 
-	ret := make([]chromem.Result, 0, len(res))
-	for _, r := range res {
-		if r.Similarity >= v.Config.SimilarityThreshold {
-			ret = append(ret, r)
+	keywordRes := v.Keyword.Search(search, opts, topK)
+	ret = fuseRRF(ret, keywordRes, min(v.Config.KeywordWeight, 1), v.Config.RRFK, func(id string) float32 {
+		doc, err := v.Collection.GetByID(ctx, id)
+		if err != nil {
+			return 0
 		}
+		return cosine(embedding, doc.Embedding)
+	})
+	// 只有关键词命中的记忆同样需要满足相似度阈值 避免 "喜欢" 等常见词命中大量无关记忆
+	ret = slices.DeleteFunc(ret, func(r Result) bool {
+		return r.Similarity < threshold
+	})
+	if len(ret) > topK {
+		ret = ret[:topK]
 	}
-
 	return ret, nil
 }
+
+func cosine(a, b []float32) float32 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return float32(dot / math.Sqrt(normA*normB))
+}
+
+// 列出满足元数据过滤条件的所有向量 不做相似度阈值过滤
+func (v *Vector) List(ctx context.Context, where map[string]string) ([]chromem.Result, error) {
+	return v.list(ctx, v.Collection, where)
+}
+
+func (v *Vector) list(ctx context.Context, collection *chromem.Collection, where map[string]string) ([]chromem.Result, error) {
+	count := collection.Count()
+	if count == 0 {
+		return nil, nil
+	}
+	// chromem 只支持基于向量的查询 这里复用初始化记忆的向量作为查询向量 避免额外的向量化请求
+	var embedding []float32
+	if doc, err := v.Collection.GetByID(ctx, InitDocumentID); err == nil {
+		embedding = doc.Embedding
+	} else {
+		embedding, err = v.EmbeddingFunc(ctx, InitDocumentID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return collection.QueryEmbedding(ctx, embedding, count, where, nil)
+}
+
+// 归档集合 被淘汰的记忆连同向量移入这里 不参与检索
+func (v *Vector) archive() (*chromem.Collection, error) {
+	return v.DB.GetOrCreateCollection(v.Config.Collection+"_archive", nil, v.EmbeddingFunc)
+}
+
+// 把记忆从集合移入归档集合 文档需要带有向量
+func (v *Vector) Archive(ctx context.Context, documents []chromem.Document) error {
+	archive, err := v.archive()
+	if err != nil {
+		return err
+	}
+	if err := archive.AddDocuments(ctx, documents, 1); err != nil {
+		return err
+	}
+	ids := make([]string, 0, len(documents))
+	for _, doc := range documents {
+		ids = append(ids, doc.ID)
+	}
+	return v.Delete(ctx, ids)
+}
+
+// 从归档集合中删除 记忆被恢复时调用 不存在时忽略
+func (v *Vector) DeleteArchived(ctx context.Context, ids []string) error {
+	archive, err := v.archive()
+	if err != nil {
+		return err
+	}
+	return archive.Delete(ctx, nil, nil, ids...)
+}
+
+// 列出归档集合中满足元数据过滤条件的记忆
+func (v *Vector) ListArchived(ctx context.Context, where map[string]string) ([]chromem.Result, error) {
+	archive, err := v.archive()
+	if err != nil {
+		return nil, err
+	}
+	return v.list(ctx, archive, where)
+}
@@ -0,0 +1,198 @@
+package vector
+
+import (
+	"math"
+	"slices"
+	"sync"
+
+	"github.com/philippgille/chromem-go"
+	"github.com/xuanlv2002/miniMem0/tokenizer"
+)
+
+/*
+	长期记忆的关键词检索
+	向量检索对产品型号 订单号 拼音和汉字混写的名字等精确词不敏感
+	这里在内存中维护记忆内容的倒排索引 使用BM25打分 与向量检索的结果通过倒数排序融合(RRF)合并
+*/
+
+const (
+	bm25K1      = 1.2
+	bm25B       = 0.75
+	defaultRRFK = 60
+)
+
+type keywordDoc struct {
+	id       string
+	content  string
+	metadata map[string]string
+	freq     map[string]int // 词频
+	length   int
+}
+
+// 关键词倒排索引 并发安全
+type KeywordIndex struct {
+	mu       sync.RWMutex
+	docs     map[string]*keywordDoc
+	postings map[string]map[string]struct{} // 词 -> 包含该词的记忆ID
+	totalLen int
+}
+
+func NewKeywordIndex() *KeywordIndex {
+	return &KeywordIndex{
+		docs:     make(map[string]*keywordDoc),
+		postings: make(map[string]map[string]struct{}),
+	}
+}
+
+// 添加或替换文档
+func (k *KeywordIndex) Add(documents ...chromem.Document) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, doc := range documents {
+		k.remove(doc.ID)
+		tokens := tokenizer.Keywords(doc.Content)
+		d := &keywordDoc{
+			id:       doc.ID,
+			content:  doc.Content,
+			metadata: doc.Metadata,
+			freq:     make(map[string]int),
+			length:   len(tokens),
+		}
+		for _, token := range tokens {
+			d.freq[token]++
+		}
+		for token := range d.freq {
+			if k.postings[token] == nil {
+				k.postings[token] = make(map[string]struct{})
+			}
+			k.postings[token][doc.ID] = struct{}{}
+		}
+		k.docs[doc.ID] = d
+		k.totalLen += d.length
+	}
+}
+
+// 删除文档
+func (k *KeywordIndex) Delete(ids ...string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, id := range ids {
+		k.remove(id)
+	}
+}
+
+func (k *KeywordIndex) remove(id string) {
+	d, ok := k.docs[id]
+	if !ok {
+		return
+	}
+	for token := range d.freq {
+		delete(k.postings[token], id)
+		if len(k.postings[token]) == 0 {
+			delete(k.postings, token)
+		}
+	}
+	delete(k.docs, id)
+	k.totalLen -= d.length
+}
+
+// 按BM25得分搜索满足过滤条件的文档 最多返回 topK 个 Similarity 为BM25得分
+func (k *KeywordIndex) Search(query string, opts SearchOptions, topK int) []chromem.Result {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if len(k.docs) == 0 {
+		return nil
+	}
+	n := float64(len(k.docs))
+	avgLen := float64(k.totalLen) / n
+	scores := make(map[string]float64)
+	seen := make(map[string]bool)
+	for _, token := range tokenizer.Keywords(query) {
+		if seen[token] {
+			continue
+		}
+		seen[token] = true
+		postings := k.postings[token]
+		if len(postings) == 0 {
+			continue
+		}
+		df := float64(len(postings))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		for id := range postings {
+			d := k.docs[id]
+			tf := float64(d.freq[token])
+			scores[id] += idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*float64(d.length)/avgLen))
+		}
+	}
+
+	ret := make([]chromem.Result, 0, len(scores))
+	for id, score := range scores {
+		d := k.docs[id]
+		if !matchWhere(d.metadata, opts.Where) || !opts.match(d.content) || !opts.inRange(d.metadata) {
+			continue
+		}
+		ret = append(ret, chromem.Result{
+			ID:         d.id,
+			Content:    d.content,
+			Metadata:   d.metadata,
+			Similarity: float32(score),
+		})
+	}
+	slices.SortFunc(ret, func(a, b chromem.Result) int {
+		switch {
+		case a.Similarity > b.Similarity:
+			return -1
+		case a.Similarity < b.Similarity:
+			return 1
+		}
+		return 0
+	})
+	if len(ret) > topK {
+		ret = ret[:topK]
+	}
+	return ret
+}
+
+// 元数据是否满足所有等值条件
+func matchWhere(metadata, where map[string]string) bool {
+	for key, value := range where {
+		if metadata[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// 倒数排序融合 得分 = (1-keywordWeight)/(k+向量排名) + keywordWeight/(k+关键词排名)
+// 只出现在关键词结果中的记忆 相似度由 similarity 计算
+func fuseRRF(vectorRes []Result, keywordRes []chromem.Result, keywordWeight float32, k int, similarity func(id string) float32) []Result {
+	if k <= 0 {
+		k = defaultRRFK
+	}
+	index := make(map[string]int, len(vectorRes)+len(keywordRes))
+	ret := make([]Result, 0, len(vectorRes)+len(keywordRes))
+	for rank, r := range vectorRes {
+		r.Score = (1 - keywordWeight) / float32(k+rank+1)
+		index[r.ID] = len(ret)
+		ret = append(ret, r)
+	}
+	for rank, r := range keywordRes {
+		score := keywordWeight / float32(k+rank+1)
+		if i, ok := index[r.ID]; ok {
+			ret[i].Score += score
+			continue
+		}
+		r.Similarity = similarity(r.ID)
+		ret = append(ret, Result{Result: r, Score: score})
+	}
+	slices.SortStableFunc(ret, func(a, b Result) int {
+		switch {
+		case a.Score > b.Score:
+			return -1
+		case a.Score < b.Score:
+			return 1
+		}
+		return 0
+	})
+	return ret
+}
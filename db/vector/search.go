@@ -0,0 +1,175 @@
+package vector
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/philippgille/chromem-go"
+	"github.com/xuanlv2002/miniMem0/model"
+)
+
+// 查询结果 Score 为排序使用的综合得分 未开启时间衰减时等于相似度 开启关键词检索时为融合得分
+type Result struct {
+	chromem.Result
+	Score float32
+}
+
+// 查询选项 零值表示不过滤并使用配置中的 TOPK 和 SIMILARITY_THRESHOLD
+type SearchOptions struct {
+	Where       map[string]string `json:"where,omitempty"`        // 元数据等值过滤 所有条件都需要满足
+	Contains    []string          `json:"contains,omitempty"`     // 内容必须包含的子串
+	NotContains []string          `json:"not_contains,omitempty"` // 内容不能包含的子串
+	TopK        int               `json:"top_k,omitempty"`        // 返回的最大数量 0表示使用 TOPK
+	Threshold   *float32          `json:"threshold,omitempty"`    // 相似度阈值 为空表示使用 SIMILARITY_THRESHOLD
+	// 记忆的时间取 appearTime 没有时取 updated_at 或 created_at 没有时间的记忆不满足时间范围条件
+	After         time.Time     `json:"after"`                    // 只返回该时间之后的记忆 零值表示不限制
+	Before        time.Time     `json:"before"`                   // 只返回该时间之前的记忆 零值表示不限制
+	HalfLife      time.Duration `json:"half_life,omitempty"`      // 时间衰减的半衰期 0表示使用 RECENCY_HALF_LIFE
+	RecencyWeight *float32      `json:"recency_weight,omitempty"` // 时间衰减的权重 为空表示使用 RECENCY_WEIGHT 为0时不衰减
+}
+
+// 返回合并了元数据过滤条件的选项 extra 中的条件优先 不修改原选项
+func (o SearchOptions) WithWhere(extra map[string]string) SearchOptions {
+	where := make(map[string]string, len(o.Where)+len(extra))
+	for k, v := range o.Where {
+		where[k] = v
+	}
+	for k, v := range extra {
+		where[k] = v
+	}
+	o.Where = where
+	return o
+}
+
+func (o SearchOptions) topK(defaultTopK int) int {
+	if o.TopK > 0 {
+		return o.TopK
+	}
+	return defaultTopK
+}
+
+func (o SearchOptions) threshold(defaultThreshold float32) float32 {
+	if o.Threshold != nil {
+		return *o.Threshold
+	}
+	return defaultThreshold
+}
+
+// chromem 的内容过滤条件 每种只支持一个子串 其余的在查询后过滤
+func (o SearchOptions) whereDocument() map[string]string {
+	whereDocument := make(map[string]string, 2)
+	if len(o.Contains) > 0 {
+		whereDocument["$contains"] = o.Contains[0]
+	}
+	if len(o.NotContains) > 0 {
+		whereDocument["$not_contains"] = o.NotContains[0]
+	}
+	if len(whereDocument) == 0 {
+		return nil
+	}
+	return whereDocument
+}
+
+// 时间衰减的半衰期和权重 半衰期或权重为0时不衰减
+func (o SearchOptions) recency(cfgHalfLife time.Duration, cfgWeight float32) (time.Duration, float32) {
+	halfLife, weight := cfgHalfLife, cfgWeight
+	if o.HalfLife > 0 {
+		halfLife = o.HalfLife
+	}
+	if o.RecencyWeight != nil {
+		weight = *o.RecencyWeight
+	}
+	if halfLife <= 0 || weight <= 0 {
+		return 0, 0
+	}
+	return halfLife, min(weight, 1)
+}
+
+// 是否需要先取出所有满足元数据条件的结果 再过滤和排序
+// chromem 每种内容过滤只支持一个子串 也不支持时间范围和重新排序
+func (o SearchOptions) needPostFilter(recency bool) bool {
+	return len(o.Contains) > 1 || len(o.NotContains) > 1 || !o.After.IsZero() || !o.Before.IsZero() || recency
+}
+
+// 记忆的时间 依次取 appearTime updated_at created_at
+func memoryTime(metadata map[string]string) (time.Time, bool) {
+	for _, key := range []string{model.MetaAppearTime, model.MetaUpdatedAt, model.MetaCreatedAt} {
+		v := metadata[key]
+		if v == "" {
+			continue
+		}
+		if t, err := time.ParseInLocation(time.DateTime, v, time.Local); err == nil {
+			return t, true
+		}
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// 是否满足时间范围条件
+func (o SearchOptions) inRange(metadata map[string]string) bool {
+	if o.After.IsZero() && o.Before.IsZero() {
+		return true
+	}
+	t, ok := memoryTime(metadata)
+	if !ok {
+		return false
+	}
+	return (o.After.IsZero() || t.After(o.After)) && (o.Before.IsZero() || t.Before(o.Before))
+}
+
+// 综合得分 (1-权重)*相似度 + 权重*0.5^(经过时间/半衰期) 没有时间的记忆不加分
+func recencyScore(r chromem.Result, now time.Time, halfLife time.Duration, weight float32) float32 {
+	if halfLife <= 0 {
+		return r.Similarity
+	}
+	var decay float64
+	if t, ok := memoryTime(r.Metadata); ok {
+		age := max(now.Sub(t), 0)
+		decay = math.Pow(0.5, float64(age)/float64(halfLife))
+	}
+	return (1-weight)*r.Similarity + weight*float32(decay)
+}
+
+// 内容是否满足所有子串过滤条件
+func (o SearchOptions) match(content string) bool {
+	for _, s := range o.Contains {
+		if !strings.Contains(content, s) {
+			return false
+		}
+	}
+	for _, s := range o.NotContains {
+		if strings.Contains(content, s) {
+			return false
+		}
+	}
+	return true
+}
+
+// 按内容 时间范围和相似度阈值过滤结果 按综合得分排序后最多返回 topK 个
+func (o SearchOptions) filter(res []chromem.Result, topK int, threshold float32, halfLife time.Duration, weight float32) []Result {
+	now := time.Now()
+	ret := make([]Result, 0, len(res))
+	for _, r := range res {
+		if r.Similarity >= threshold && o.match(r.Content) && o.inRange(r.Metadata) {
+			ret = append(ret, Result{Result: r, Score: recencyScore(r, now, halfLife, weight)})
+		}
+	}
+	slices.SortStableFunc(ret, func(a, b Result) int {
+		switch {
+		case a.Score > b.Score:
+			return -1
+		case a.Score < b.Score:
+			return 1
+		}
+		return 0
+	})
+	if len(ret) > topK {
+		ret = ret[:topK]
+	}
+	return ret
+}
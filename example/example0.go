@@ -21,51 +21,60 @@ func Example0() {
 		fmt.Println("Error initializing memory system:", err)
 		return
 	}
-	prompt, err := mem.ProcessInput("我朋友是一个程序员，他的名字叫小明。")
+	defer mem.Close()
+	// 示例使用固定的用户和会话
+	userID, sessionID := "example-user", "example-session"
+	prompt, err := mem.ProcessInput(userID, sessionID, "我朋友是一个程序员，他的名字叫小明。")
 	fmt.Println("Prompt:", prompt)
 	if err != nil {
 		fmt.Println("Error processing input:", err)
 		return
 	}
-	err = mem.ProcessOutput("哦,小明是一个很棒的程序员！你能告诉我更多关于他的信息吗？")
+	err = mem.ProcessOutput(userID, sessionID, "哦,小明是一个很棒的程序员！你能告诉我更多关于他的信息吗？")
 
-	prompt, err = mem.ProcessInput("我也是一个程序员，我的名字叫小柴")
+	prompt, err = mem.ProcessInput(userID, sessionID, "我也是一个程序员，我的名字叫小柴")
 	fmt.Println("Prompt:", prompt)
 	if err != nil {
 		fmt.Println("Error processing input:", err)
 		return
 	}
-	err = mem.ProcessOutput("哦,小柴也是一个很棒的程序员！你们两个都是程序员，真不错！")
+	err = mem.ProcessOutput(userID, sessionID, "哦,小柴也是一个很棒的程序员！你们两个都是程序员，真不错！")
 
-	prompt, err = mem.ProcessInput("我感觉写代码很有趣，尤其是解决问题的时候。")
+	prompt, err = mem.ProcessInput(userID, sessionID, "我感觉写代码很有趣，尤其是解决问题的时候。")
 	fmt.Println("Prompt:", prompt)
 	if err != nil {
 		fmt.Println("Error processing input:", err)
 		return
 	}
-	err = mem.ProcessOutput("是的，编程确实很有趣！解决问题的过程可以非常有成就感。")
+	err = mem.ProcessOutput(userID, sessionID, "是的，编程确实很有趣！解决问题的过程可以非常有成就感。")
 
-	prompt, err = mem.ProcessInput("你喜欢编程吗？")
+	prompt, err = mem.ProcessInput(userID, sessionID, "你喜欢编程吗？")
 	fmt.Println("Prompt:", prompt)
 	if err != nil {
 		fmt.Println("Error processing input:", err)
 		return
 	}
-	err = mem.ProcessOutput("我喜欢编程！它让我能够创造出有用的工具和应用程序。")
+	err = mem.ProcessOutput(userID, sessionID, "我喜欢编程！它让我能够创造出有用的工具和应用程序。")
 
-	prompt, err = mem.ProcessInput("我叫什么?")
+	prompt, err = mem.ProcessInput(userID, sessionID, "我叫什么?")
 	fmt.Println("Prompt:", prompt)
 	if err != nil {
 		fmt.Println("Error processing input:", err)
 		return
 	}
-	err = mem.ProcessOutput("你叫小柴。你是一个程序员。")
+	err = mem.ProcessOutput(userID, sessionID, "你叫小柴。你是一个程序员。")
 
-	prompt, err = mem.ProcessInput("小明是谁?")
+	prompt, err = mem.ProcessInput(userID, sessionID, "小明是谁?")
 	fmt.Println("Prompt:", prompt)
 	if err != nil {
 		fmt.Println("Error processing input:", err)
 		return
 	}
-	err = mem.ProcessOutput("小明是你的朋友，他也是一个程序员。")
+	err = mem.ProcessOutput(userID, sessionID, "小明是你的朋友，他也是一个程序员。")
+	if err != nil {
+		fmt.Println("Error processing output:", err)
+		return
+	}
+	// 记忆在后台更新 退出前等待完成
+	mem.WaitDone()
 }
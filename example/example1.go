@@ -24,9 +24,13 @@ func Example1() {
 	if err != nil {
 		panic(err)
 	}
+	defer memSys.Close()
 	// Use the memory system
 	llmModel := llm.NewLLM(conf.GetChatConfig())
 
+	// 命令行示例使用固定的用户和会话
+	userID, sessionID := "example-user", "example-session"
+
 	reader := bufio.NewReader(os.Stdin)
 	fmt.Println("欢迎来到个性化智能服务,输入q退出:")
 
@@ -38,7 +42,7 @@ func Example1() {
 			fmt.Println("退出程序")
 			return
 		}
-		prompt, err := memSys.ProcessInput(input)
+		prompt, err := memSys.ProcessInput(userID, sessionID, input)
 		if err != nil {
 			fmt.Println("处理输入时出错:", err)
 			return
@@ -58,6 +62,6 @@ func Example1() {
 			return
 		}
 		fmt.Println("大模型响应:", response.Content)
-		memSys.ProcessOutput(response.Content)
+		memSys.ProcessOutput(userID, sessionID, response.Content)
 	}
 }
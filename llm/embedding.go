@@ -3,7 +3,7 @@ package llm
 import (
 	"context"
 	"errors"
-
+	"fmt"
 	"sync"
 
 	"github.com/philippgille/chromem-go"
@@ -11,8 +11,40 @@ import (
 	"github.com/xuanlv2002/miniMem0/config"
 )
 
+// Embedder 文本向量化能力 记忆系统通过它把文本转为向量
+type Embedder interface {
+	Embed(ctx context.Context, text string) ([]float32, error)
+	// 批量向量化 返回结果与输入一一对应
+	EmbedBatch(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// 未配置批量大小时的默认值
+const DefaultEmbeddingBatchSize = 32
+
+const (
+	EmbeddingProviderOpenAI = "openai" // OpenAI 兼容接口
+	EmbeddingProviderLocal  = "local"  // 离线哈希向量
+)
+
+// 根据配置中的 PROVIDER 创建向量化实现 未配置时使用 OpenAI 兼容接口
+func NewEmbedder(cfg *config.EmbeddingConfig) (Embedder, error) {
+	switch cfg.Provider {
+	case "", EmbeddingProviderOpenAI:
+		return NewEmbedding(cfg), nil
+	case EmbeddingProviderLocal:
+		return NewLocalEmbedding(cfg.Dimensions), nil
+	default:
+		return nil, fmt.Errorf("unknown embedding provider: %s", cfg.Provider)
+	}
+}
+
+// 将 Embedder 转为向量数据库使用的向量化函数
+func EmbeddingFunc(embedder Embedder) chromem.EmbeddingFunc {
+	return embedder.Embed
+}
+
 /*
-负责和底层大模型的交互，主要封装了 Chat 和 ChatAsync 两个方法
+负责和底层向量模型的交互
 */
 func NewEmbedding(cfg *config.EmbeddingConfig) *Embedding {
 	var openapiConfig openai.ClientConfig
@@ -51,12 +83,56 @@ func (l *Embedding) Embedding(ctx context.Context, messages string) (*openai.Emb
 	return nil, errors.New("no choices found")
 }
 
-func (l *Embedding) GetEmbeddingFunc() chromem.EmbeddingFunc {
-	return func(ctx context.Context, text string) ([]float32, error) {
-		embedding, err := l.Embedding(ctx, text)
-		if err != nil {
+func (l *Embedding) Embed(ctx context.Context, text string) ([]float32, error) {
+	embedding, err := l.Embedding(ctx, text)
+	if err != nil {
+		return nil, err
+	}
+	return embedding.Embedding, nil
+}
+
+// 批量向量化 按 BatchSize 分批请求 并根据返回的 Index 还原顺序
+func (l *Embedding) EmbedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	batchSize := l.Config.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultEmbeddingBatchSize
+	}
+	ret := make([][]float32, len(texts))
+	for start := 0; start < len(texts); start += batchSize {
+		end := min(start+batchSize, len(texts))
+		if err := l.embedChunk(ctx, texts[start:end], ret[start:end]); err != nil {
 			return nil, err
 		}
-		return embedding.Embedding, nil
 	}
+	return ret, nil
+}
+
+func (l *Embedding) embedChunk(ctx context.Context, texts []string, out [][]float32) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	req := openai.EmbeddingRequest{
+		Dimensions: l.Config.Dimensions,
+		Model:      l.Config.Model,
+		Input:      texts,
+	}
+	resp, err := l.Client.CreateEmbeddings(ctx, req)
+	if err != nil {
+		return err
+	}
+	for _, data := range resp.Data {
+		if data.Index < 0 || data.Index >= len(out) {
+			return fmt.Errorf("embedding index %d out of range", data.Index)
+		}
+		out[data.Index] = data.Embedding
+	}
+	for i := range out {
+		if out[i] == nil {
+			return fmt.Errorf("missing embedding for input %d", i)
+		}
+	}
+	return nil
+}
+
+func (l *Embedding) GetEmbeddingFunc() chromem.EmbeddingFunc {
+	return EmbeddingFunc(l)
 }
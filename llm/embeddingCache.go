@@ -0,0 +1,118 @@
+package llm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+)
+
+/*
+	向量缓存
+	以(向量模型, 维度, 文本sha256)为键缓存向量 重复的查询和未变化的记忆内容不再请求向量模型
+*/
+
+// 向量缓存的持久化存储 由 sqldb.SqlHandler 实现
+type EmbeddingCacheStore interface {
+	GetEmbeddings(model string, dimensions int, hashes []string) (map[string][]float32, error)
+	PutEmbeddings(model string, dimensions int, vectors map[string][]float32) error
+	TouchEmbeddings(model string, dimensions int, hashes []string) error
+	EvictEmbeddings(maxSize int) error
+}
+
+type CachedEmbedder struct {
+	embedder   Embedder
+	store      EmbeddingCacheStore
+	model      string
+	dimensions int
+	maxSize    int // 最大缓存数量 <=0 表示不限制
+	hits       atomic.Int64
+	misses     atomic.Int64
+}
+
+var _ Embedder = (*CachedEmbedder)(nil)
+
+func NewCachedEmbedder(embedder Embedder, store EmbeddingCacheStore, model string, dimensions int, maxSize int) *CachedEmbedder {
+	return &CachedEmbedder{
+		embedder:   embedder,
+		store:      store,
+		model:      model,
+		dimensions: dimensions,
+		maxSize:    maxSize,
+	}
+}
+
+// 返回缓存命中和未命中的次数
+func (c *CachedEmbedder) Stats() (hits, misses int64) {
+	return c.hits.Load(), c.misses.Load()
+}
+
+func (c *CachedEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	ret, err := c.EmbedBatch(ctx, []string{text})
+	if err != nil {
+		return nil, err
+	}
+	return ret[0], nil
+}
+
+// 先查缓存 只对未命中的文本请求向量模型 缓存读写失败时不影响向量化结果
+func (c *CachedEmbedder) EmbedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	hashes := make([]string, len(texts))
+	for i, text := range texts {
+		sum := sha256.Sum256([]byte(text))
+		hashes[i] = hex.EncodeToString(sum[:])
+	}
+
+	cached, err := c.store.GetEmbeddings(c.model, c.dimensions, hashes)
+	if err != nil {
+		logrus.Warnf("get embedding cache error: %v", err)
+		cached = nil
+	}
+
+	ret := make([][]float32, len(texts))
+	var hitHashes []string
+	var missTexts []string
+	var missIndexes []int
+	for i, hash := range hashes {
+		if vector, ok := cached[hash]; ok {
+			ret[i] = vector
+			hitHashes = append(hitHashes, hash)
+			continue
+		}
+		missTexts = append(missTexts, texts[i])
+		missIndexes = append(missIndexes, i)
+	}
+	c.hits.Add(int64(len(hitHashes)))
+	c.misses.Add(int64(len(missTexts)))
+
+	if len(hitHashes) > 0 {
+		if err := c.store.TouchEmbeddings(c.model, c.dimensions, hitHashes); err != nil {
+			logrus.Warnf("touch embedding cache error: %v", err)
+		}
+	}
+	if len(missTexts) == 0 {
+		return ret, nil
+	}
+
+	embeddings, err := c.embedder.EmbedBatch(ctx, missTexts)
+	if err != nil {
+		return nil, err
+	}
+	vectors := make(map[string][]float32, len(embeddings))
+	for i, index := range missIndexes {
+		ret[index] = embeddings[i]
+		vectors[hashes[index]] = embeddings[i]
+	}
+	if err := c.store.PutEmbeddings(c.model, c.dimensions, vectors); err != nil {
+		logrus.Warnf("put embedding cache error: %v", err)
+		return ret, nil
+	}
+	if c.maxSize > 0 {
+		if err := c.store.EvictEmbeddings(c.maxSize); err != nil {
+			logrus.Warnf("evict embedding cache error: %v", err)
+		}
+	}
+	return ret, nil
+}
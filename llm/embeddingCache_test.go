@@ -0,0 +1,155 @@
+package llm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/xuanlv2002/miniMem0/config"
+	"github.com/xuanlv2002/miniMem0/db/sqldb"
+)
+
+// 记录实际请求向量化的文本
+type countingEmbedder struct {
+	Embedder
+	texts []string
+}
+
+func (c *countingEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	c.texts = append(c.texts, text)
+	return c.Embedder.Embed(ctx, text)
+}
+
+func (c *countingEmbedder) EmbedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	c.texts = append(c.texts, texts...)
+	return c.Embedder.EmbedBatch(ctx, texts)
+}
+
+func newTestCacheStore(t *testing.T) *sqldb.SqlHandler {
+	t.Helper()
+	store, err := sqldb.NewSQL(&config.SqlConfig{Path: filepath.Join(t.TempDir(), "cache.db")})
+	if err != nil {
+		t.Fatalf("NewSQL: %v", err)
+	}
+	return store
+}
+
+func textHash(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestCachedEmbedder(t *testing.T) {
+	ctx := context.Background()
+	store := newTestCacheStore(t)
+	local := NewLocalEmbedding(16)
+	inner := &countingEmbedder{Embedder: local}
+	cached := NewCachedEmbedder(inner, store, "local", 16, 0)
+
+	embed := func(c *CachedEmbedder, texts ...string) [][]float32 {
+		t.Helper()
+		vectors, err := c.EmbedBatch(ctx, texts)
+		if err != nil {
+			t.Fatalf("EmbedBatch: %v", err)
+		}
+		for i, text := range texts {
+			want, _ := local.Embed(ctx, text)
+			if !slices.Equal(vectors[i], want) {
+				t.Fatalf("vector of %q differs from the embedder", text)
+			}
+		}
+		return vectors
+	}
+	checkStats := func(c *CachedEmbedder, wantHits, wantMisses int64, wantTexts ...string) {
+		t.Helper()
+		if hits, misses := c.Stats(); hits != wantHits || misses != wantMisses {
+			t.Fatalf("Stats() = %d, %d, want %d, %d", hits, misses, wantHits, wantMisses)
+		}
+		if !slices.Equal(inner.texts, wantTexts) {
+			t.Fatalf("embedded %q, want %q", inner.texts, wantTexts)
+		}
+	}
+
+	embed(cached, "我叫小明", "喜欢游泳")
+	checkStats(cached, 0, 2, "我叫小明", "喜欢游泳")
+
+	// 只对未命中的文本请求向量模型
+	embed(cached, "喜欢游泳", "对花生过敏", "我叫小明")
+	checkStats(cached, 2, 3, "我叫小明", "喜欢游泳", "对花生过敏")
+
+	// 不同的向量模型和维度使用不同的缓存
+	other := NewCachedEmbedder(inner, store, "other", 16, 0)
+	embed(other, "我叫小明")
+	checkStats(other, 0, 1, "我叫小明", "喜欢游泳", "对花生过敏", "我叫小明")
+	resized := NewCachedEmbedder(inner, store, "local", 32, 0)
+	if _, err := resized.Embed(ctx, "我叫小明"); err != nil {
+		t.Fatalf("Embed: %v", err)
+	}
+	checkStats(resized, 0, 1, "我叫小明", "喜欢游泳", "对花生过敏", "我叫小明", "我叫小明")
+	embed(other, "我叫小明")
+	checkStats(other, 1, 1, "我叫小明", "喜欢游泳", "对花生过敏", "我叫小明", "我叫小明")
+}
+
+func TestEvictEmbeddings(t *testing.T) {
+	ctx := context.Background()
+	store := newTestCacheStore(t)
+	cached := NewCachedEmbedder(NewLocalEmbedding(16), store, "local", 16, 2)
+	embed := func(text string) {
+		t.Helper()
+		if _, err := cached.Embed(ctx, text); err != nil {
+			t.Fatalf("Embed: %v", err)
+		}
+		// 保证每次使用的时间不同
+		time.Sleep(10 * time.Millisecond)
+	}
+	cachedTexts := func() []string {
+		t.Helper()
+		texts := []string{"a", "b", "c", "d"}
+		hashes := make([]string, len(texts))
+		for i, text := range texts {
+			hashes[i] = textHash(text)
+		}
+		vectors, err := store.GetEmbeddings("local", 16, hashes)
+		if err != nil {
+			t.Fatalf("GetEmbeddings: %v", err)
+		}
+		var ret []string
+		for i, text := range texts {
+			if _, ok := vectors[hashes[i]]; ok {
+				ret = append(ret, text)
+			}
+		}
+		return ret
+	}
+
+	embed("a")
+	embed("b")
+	// 命中刷新最近使用时间 超出容量时淘汰最久未使用的 b
+	embed("a")
+	embed("c")
+	if got := cachedTexts(); !slices.Equal(got, []string{"a", "c"}) {
+		t.Fatalf("cached %q, want a and c", got)
+	}
+	embed("d")
+	if got := cachedTexts(); !slices.Equal(got, []string{"c", "d"}) {
+		t.Fatalf("cached %q, want c and d", got)
+	}
+
+	// 未超出容量时不淘汰
+	if err := store.EvictEmbeddings(2); err != nil {
+		t.Fatalf("EvictEmbeddings: %v", err)
+	}
+	if got := cachedTexts(); len(got) != 2 {
+		t.Fatalf("cached %q, want 2 entries", got)
+	}
+	if err := store.EvictEmbeddings(0); err != nil {
+		t.Fatalf("EvictEmbeddings: %v", err)
+	}
+	if got := cachedTexts(); len(got) != 0 {
+		t.Fatalf("cached %q, want none", got)
+	}
+}
@@ -11,6 +11,18 @@ import (
 	"github.com/xuanlv2002/miniMem0/config"
 )
 
+// ChatModel 记忆系统依赖的大模型能力 可替换为非 OpenAI 的实现或测试用的假模型
+type ChatModel interface {
+	// 普通对话 返回完整回复
+	Chat(ctx context.Context, messages []openai.ChatCompletionMessage) (*openai.ChatCompletionMessage, error)
+	// 流式对话 每收到一段内容回调一次 返回完整回复
+	ChatAsync(ctx context.Context, messages []openai.ChatCompletionMessage, caller ...func(body string)) (string, error)
+	// 带工具调用的对话
+	ChatWithTool(ctx context.Context, messages []openai.ChatCompletionMessage, tools []openai.Tool) (*openai.ChatCompletionMessage, error)
+}
+
+var _ ChatModel = (*LLM)(nil)
+
 /*
 负责和底层大模型的交互，主要封装了 Chat 和 ChatAsync 两个方法
 */
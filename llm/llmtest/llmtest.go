@@ -0,0 +1,149 @@
+package llmtest
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/xuanlv2002/miniMem0/llm"
+)
+
+/*
+	用于测试的脚本化大模型
+	按顺序返回预设的回复 并记录每次调用的消息 结果完全确定
+*/
+
+// 预设回复用尽时返回的错误
+var ErrScriptExhausted = errors.New("llmtest: no scripted response left")
+
+// 一条预设回复 Err 不为空时返回错误
+type Response struct {
+	Content   string
+	ToolCalls []openai.ToolCall
+	Err       error
+}
+
+// 一次调用的记录
+type Call struct {
+	Messages []openai.ChatCompletionMessage
+	Tools    []openai.Tool
+	Schema   *llm.OutputSchema // 结构化输出调用时的 Schema
+}
+
+type ScriptedChatModel struct {
+	mu        sync.Mutex
+	responses []Response
+	calls     []Call
+	// 设置后优先使用 Handler 生成回复 可根据输入动态返回
+	Handler func(messages []openai.ChatCompletionMessage) Response
+}
+
+var (
+	_ llm.ChatModel           = (*ScriptedChatModel)(nil)
+	_ llm.StructuredChatModel = (*ScriptedChatModel)(nil)
+)
+
+// 创建按顺序返回 contents 的假模型
+func NewScriptedChatModel(contents ...string) *ScriptedChatModel {
+	m := &ScriptedChatModel{}
+	for _, content := range contents {
+		m.responses = append(m.responses, Response{Content: content})
+	}
+	return m
+}
+
+// 追加预设回复
+func (m *ScriptedChatModel) Push(responses ...Response) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.responses = append(m.responses, responses...)
+}
+
+// 返回所有调用记录
+func (m *ScriptedChatModel) Calls() []Call {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Call(nil), m.calls...)
+}
+
+// 剩余的预设回复数量
+func (m *ScriptedChatModel) Remaining() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.responses)
+}
+
+func (m *ScriptedChatModel) next(call Call) Response {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = append(m.calls, call)
+	if m.Handler != nil {
+		return m.Handler(call.Messages)
+	}
+	if len(m.responses) == 0 {
+		return Response{Err: ErrScriptExhausted}
+	}
+	resp := m.responses[0]
+	m.responses = m.responses[1:]
+	return resp
+}
+
+func (m *ScriptedChatModel) Chat(ctx context.Context, messages []openai.ChatCompletionMessage) (*openai.ChatCompletionMessage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	resp := m.next(Call{Messages: messages})
+	if resp.Err != nil {
+		return nil, resp.Err
+	}
+	return &openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleAssistant,
+		Content: resp.Content,
+	}, nil
+}
+
+// 按空白切分回复内容 逐段回调 模拟流式输出
+func (m *ScriptedChatModel) ChatAsync(ctx context.Context, messages []openai.ChatCompletionMessage, caller ...func(body string)) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	resp := m.next(Call{Messages: messages})
+	if resp.Err != nil {
+		return "", resp.Err
+	}
+	if len(caller) > 0 {
+		for _, chunk := range strings.SplitAfter(resp.Content, " ") {
+			caller[0](chunk)
+		}
+	}
+	return resp.Content, nil
+}
+
+func (m *ScriptedChatModel) ChatWithTool(ctx context.Context, messages []openai.ChatCompletionMessage, tools []openai.Tool) (*openai.ChatCompletionMessage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	resp := m.next(Call{Messages: messages, Tools: tools})
+	if resp.Err != nil {
+		return nil, resp.Err
+	}
+	return &openai.ChatCompletionMessage{
+		Role:      openai.ChatMessageRoleAssistant,
+		Content:   resp.Content,
+		ToolCalls: resp.ToolCalls,
+	}, nil
+}
+
+// 直接返回预设回复的内容 Schema 记录在调用记录中
+func (m *ScriptedChatModel) ChatStructured(ctx context.Context, messages []openai.ChatCompletionMessage, schema *llm.OutputSchema) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	resp := m.next(Call{Messages: messages, Schema: schema})
+	if resp.Err != nil {
+		return "", resp.Err
+	}
+	return resp.Content, nil
+}
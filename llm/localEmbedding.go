@@ -0,0 +1,126 @@
+package llm
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"strings"
+	"unicode"
+)
+
+/*
+	离线的哈希向量化实现 不依赖任何外部服务
+	将文本切分为词、字符n-gram 通过哈希映射到固定维度的向量(hashing trick)
+	语义能力远弱于模型向量 仅用于开发、CI 以及完全离线的部署
+*/
+
+// 未配置维度时的默认维度
+const DefaultLocalEmbeddingDimensions = 256
+
+type LocalEmbedding struct {
+	Dimensions int
+}
+
+var _ Embedder = (*LocalEmbedding)(nil)
+
+func NewLocalEmbedding(dimensions int) *LocalEmbedding {
+	if dimensions <= 0 {
+		dimensions = DefaultLocalEmbeddingDimensions
+	}
+	return &LocalEmbedding{Dimensions: dimensions}
+}
+
+func (l *LocalEmbedding) Embed(ctx context.Context, text string) ([]float32, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	vector := make([]float32, l.Dimensions)
+	for _, feature := range localFeatures(text) {
+		h := fnv.New64a()
+		h.Write([]byte(feature))
+		sum := h.Sum64()
+		// 低位决定维度 高位决定符号 减少哈希冲突带来的偏差
+		index := int(sum % uint64(l.Dimensions))
+		if sum>>63 == 1 {
+			vector[index] -= 1
+		} else {
+			vector[index] += 1
+		}
+	}
+	normalize(vector)
+	return vector, nil
+}
+
+func (l *LocalEmbedding) EmbedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	ret := make([][]float32, len(texts))
+	for i, text := range texts {
+		vector, err := l.Embed(ctx, text)
+		if err != nil {
+			return nil, err
+		}
+		ret[i] = vector
+	}
+	return ret, nil
+}
+
+// 提取文本特征 中日韩文字使用单字和相邻双字 其他文字使用单词和单词内的三字组
+func localFeatures(text string) []string {
+	var features []string
+	var word []rune
+	var prevCJK rune
+
+	flushWord := func() {
+		if len(word) == 0 {
+			return
+		}
+		features = append(features, "w:"+string(word))
+		padded := append(append([]rune{'<'}, word...), '>')
+		for i := 0; i+3 <= len(padded); i++ {
+			features = append(features, "g:"+string(padded[i:i+3]))
+		}
+		word = word[:0]
+	}
+
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case isCJK(r):
+			flushWord()
+			features = append(features, "c:"+string(r))
+			if prevCJK != 0 {
+				features = append(features, "b:"+string([]rune{prevCJK, r}))
+			}
+			prevCJK = r
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			prevCJK = 0
+			word = append(word, r)
+		default:
+			prevCJK = 0
+			flushWord()
+		}
+	}
+	flushWord()
+	return features
+}
+
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) ||
+		unicode.Is(unicode.Hiragana, r) ||
+		unicode.Is(unicode.Katakana, r) ||
+		unicode.Is(unicode.Hangul, r)
+}
+
+// 归一化向量 空文本时返回固定的单位向量 避免出现零向量
+func normalize(vector []float32) {
+	var sum float64
+	for _, v := range vector {
+		sum += float64(v) * float64(v)
+	}
+	if sum == 0 {
+		vector[0] = 1
+		return
+	}
+	norm := float32(math.Sqrt(sum))
+	for i := range vector {
+		vector[i] /= norm
+	}
+}
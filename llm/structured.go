@@ -0,0 +1,171 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/sashabaranov/go-openai/jsonschema"
+)
+
+// 结构化输出方式 对应 LLM.RESPONSE_FORMAT
+const (
+	ResponseFormatNone       = "none"        // 不使用结构化输出 只依靠提示词约束
+	ResponseFormatJSONObject = "json_object" // 要求输出合法的JSON对象
+	ResponseFormatJSONSchema = "json_schema" // 要求输出符合 Schema 的JSON
+	ResponseFormatTool       = "tool"        // 强制调用一个参数为 Schema 的工具 取工具参数作为输出
+)
+
+// 要求模型输出的JSON结构
+type OutputSchema struct {
+	Name        string
+	Description string
+	Schema      jsonschema.Definition
+	Strict      bool // 严格模式下 Schema 中所有字段都必须为 required 且不允许额外字段
+}
+
+// StructuredChatModel 支持结构化输出的大模型
+// 未实现该接口的模型 记忆系统只依靠提示词约束输出并使用宽松解析
+type StructuredChatModel interface {
+	// 按 schema 输出JSON 返回JSON文本
+	ChatStructured(ctx context.Context, messages []openai.ChatCompletionMessage, schema *OutputSchema) (string, error)
+}
+
+var _ StructuredChatModel = (*LLM)(nil)
+
+// 按配置的 RESPONSE_FORMAT 请求结构化输出
+func (l *LLM) ChatStructured(ctx context.Context, messages []openai.ChatCompletionMessage, schema *OutputSchema) (string, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	req := openai.ChatCompletionRequest{
+		Model:       l.Config.Model,
+		Temperature: l.Config.Temperature,
+		Messages:    messages,
+	}
+	format := l.Config.ResponseFormat
+	switch format {
+	case "", ResponseFormatNone:
+	case ResponseFormatJSONObject:
+		req.ResponseFormat = &openai.ChatCompletionResponseFormat{
+			Type: openai.ChatCompletionResponseFormatTypeJSONObject,
+		}
+	case ResponseFormatJSONSchema:
+		req.ResponseFormat = &openai.ChatCompletionResponseFormat{
+			Type: openai.ChatCompletionResponseFormatTypeJSONSchema,
+			JSONSchema: &openai.ChatCompletionResponseFormatJSONSchema{
+				Name:        schema.Name,
+				Description: schema.Description,
+				Schema:      &schema.Schema,
+				Strict:      schema.Strict,
+			},
+		}
+	case ResponseFormatTool:
+		req.Tools = []openai.Tool{{
+			Type: openai.ToolTypeFunction,
+			Function: &openai.FunctionDefinition{
+				Name:        schema.Name,
+				Description: schema.Description,
+				Parameters:  &schema.Schema,
+				Strict:      schema.Strict,
+			},
+		}}
+		req.ToolChoice = openai.ToolChoice{
+			Type:     openai.ToolTypeFunction,
+			Function: openai.ToolFunction{Name: schema.Name},
+		}
+	default:
+		return "", fmt.Errorf("unsupported response format: %s", format)
+	}
+
+	resp, err := l.Client.CreateChatCompletion(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Choices) == 0 {
+		return "", errors.New("no choices found")
+	}
+	message := resp.Choices[0].Message
+	if format == ResponseFormatTool {
+		for _, call := range message.ToolCalls {
+			if call.Function.Name == schema.Name {
+				return call.Function.Arguments, nil
+			}
+		}
+	}
+	return message.Content, nil
+}
+
+// 宽松解析模型输出的JSON
+// 兼容代码块包裹和前后夹杂的说明文字 依次尝试输出中每个完整的JSON对象 使用第一个合法的对象
+func ParseJSON(content string, v any) error {
+	var firstErr error
+	for start := strings.IndexByte(content, '{'); start >= 0; {
+		end := matchBrace(content, start)
+		if end < 0 {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("incomplete JSON object in response: %q", truncate(content[start:], 200))
+			}
+		} else {
+			candidate := []byte(content[start : end+1])
+			if json.Valid(candidate) {
+				return json.Unmarshal(candidate, v)
+			}
+			if firstErr == nil {
+				firstErr = json.Unmarshal(candidate, v)
+			}
+		}
+		next := strings.IndexByte(content[start+1:], '{')
+		if next < 0 {
+			break
+		}
+		start += next + 1
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+	return fmt.Errorf("no JSON object found in response: %q", truncate(content, 200))
+}
+
+// 返回与 start 处左括号匹配的右括号位置 忽略字符串中的括号 没有匹配时返回-1
+func matchBrace(s string, start int) int {
+	depth := 0
+	inString := false
+	escaped := false
+	for i := start; i < len(s); i++ {
+		c := s[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n]) + "..."
+}
@@ -0,0 +1,59 @@
+package llm
+
+import "testing"
+
+func TestParseJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+		wantErr bool
+	}{
+		{"plain", `{"text":"我叫小明"}`, "我叫小明", false},
+		{"code fence", "```json\n{\"text\":\"我叫小明\"}\n```", "我叫小明", false},
+		{"leading prose", "好的 以下是结果:\n{\"text\":\"我叫小明\"} 希望有帮助", "我叫小明", false},
+		{"braces in string", `{"text":"用 } 和 { 分隔 \"引号\" 里也有 }"}`, `用 } 和 { 分隔 "引号" 里也有 }`, false},
+		{"nested", `{"text":"外层","extra":{"a":{"b":1}}}`, "外层", false},
+		{"several objects", `{"text":"第一个"} {"text":"第二个"}`, "第一个", false},
+		{"invalid then valid", `{text: 无效} 重新输出 {"text":"有效"}`, "有效", false},
+		{"invalid only", `{text: 无效}`, "", true},
+		{"incomplete", `{"text":"没有结束`, "", true},
+		{"no object", "没有JSON", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v struct {
+				Text string `json:"text"`
+			}
+			err := ParseJSON(tt.content, &v)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseJSON(%q) error = %v, wantErr %v", tt.content, err, tt.wantErr)
+			}
+			if v.Text != tt.want {
+				t.Fatalf("ParseJSON(%q) text = %q, want %q", tt.content, v.Text, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchBrace(t *testing.T) {
+	tests := []struct {
+		s     string
+		start int
+		want  int
+	}{
+		{`{}`, 0, 1},
+		{`{"a":{"b":1}} {}`, 0, 12},
+		{`{"a":{"b":1}}`, 5, 11},
+		{`{"a":"}"}`, 0, 8},
+		{`{"a":"\"}"}`, 0, 10},
+		{`{"a":"\\"}`, 0, 9},
+		{`{"a":{}`, 0, -1},
+		{`{"a":"}`, 0, -1},
+	}
+	for _, tt := range tests {
+		if got := matchBrace(tt.s, tt.start); got != tt.want {
+			t.Errorf("matchBrace(%q, %d) = %d, want %d", tt.s, tt.start, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package memory
+
+import (
+	"github.com/xuanlv2002/miniMem0/config"
+	"github.com/xuanlv2002/miniMem0/model"
+	"github.com/xuanlv2002/miniMem0/prompt"
+	"github.com/xuanlv2002/miniMem0/tokenizer"
+)
+
+/*
+	按token预算组装提示词
+	先让每个部分满足自己的预算 再满足总预算
+	裁剪优先级: 相关度最低的长期记忆 -> 关系记忆 -> 最早的短期记忆 -> 截断摘要 -> 截断用户输入
+*/
+
+// 组成提示词的各个部分
+type promptSections struct {
+	templates *prompt.Templates
+	context   *model.ContextMemory
+	long      *model.LongMemory
+	short     *model.ShortMemory
+	input     *model.OriginalMemory
+}
+
+func (p *promptSections) summaryPrompt() string { return p.context.GetPromptWith(p.templates) }
+func (p *promptSections) longPrompt() string    { return p.long.GetPromptWith(p.templates) }
+func (p *promptSections) shortPrompt() string   { return p.short.GetPromptWith(p.templates) }
+func (p *promptSections) inputPrompt() string   { return p.input.GetInputPromptWith(p.templates) }
+
+func (p *promptSections) String() string {
+	return p.summaryPrompt() + p.longPrompt() + p.shortPrompt() + p.inputPrompt()
+}
+
+// 统计各部分的token用量
+func (p *promptSections) usage(tok tokenizer.Tokenizer, budget int) *model.TokenUsage {
+	usage := &model.TokenUsage{
+		Summary: tok.Count(p.summaryPrompt()),
+		Long:    tok.Count(p.longPrompt()),
+		Short:   tok.Count(p.shortPrompt()),
+		Input:   tok.Count(p.inputPrompt()),
+		Budget:  budget,
+	}
+	usage.Total = usage.Summary + usage.Long + usage.Short + usage.Input
+	return usage
+}
+
+// 按预算裁剪各部分 会修改传入的记忆 返回是否发生了裁剪
+func (p *promptSections) fit(tok tokenizer.Tokenizer, budget *config.PromptBudgetConfig) bool {
+	truncated := false
+	// 各部分预算
+	if budget.Long > 0 {
+		for tok.Count(p.longPrompt()) > budget.Long && p.dropLong() {
+			truncated = true
+		}
+	}
+	if budget.Short > 0 {
+		for tok.Count(p.shortPrompt()) > budget.Short && p.dropShort() {
+			truncated = true
+		}
+	}
+	if budget.Summary > 0 && tok.Count(p.summaryPrompt()) > budget.Summary {
+		p.context.Summary = truncateText(p.context.Summary, func(s string) bool {
+			p.context.Summary = s
+			return tok.Count(p.summaryPrompt()) <= budget.Summary
+		})
+		truncated = true
+	}
+	if budget.Input > 0 && tok.Count(p.inputPrompt()) > budget.Input {
+		p.input.Content = truncateText(p.input.Content, func(s string) bool {
+			p.input.Content = s
+			return tok.Count(p.inputPrompt()) <= budget.Input
+		})
+		truncated = true
+	}
+
+	// 总预算
+	if budget.Total <= 0 {
+		return truncated
+	}
+	over := func() bool { return p.usage(tok, budget.Total).Total > budget.Total }
+	for over() && (p.dropLong() || p.dropShort()) {
+		truncated = true
+	}
+	if over() {
+		p.context.Summary = truncateText(p.context.Summary, func(s string) bool {
+			p.context.Summary = s
+			return !over()
+		})
+		truncated = true
+	}
+	if over() {
+		p.input.Content = truncateText(p.input.Content, func(s string) bool {
+			p.input.Content = s
+			return !over()
+		})
+	}
+	return truncated
+}
+
+// 丢弃最终排名最低的一条长期记忆 没有长期记忆时丢弃最久未被抽取到的一条关系
+func (p *promptSections) dropLong() bool {
+	items := p.long.VectorMemorys
+	if len(items) == 0 {
+		if len(p.long.Relations) == 0 {
+			return false
+		}
+		p.long.Relations = p.long.Relations[:len(p.long.Relations)-1]
+		return true
+	}
+	score := rankScore(items)
+	lowest := 0
+	for i, item := range items {
+		// 得分相同时丢弃排在后面的
+		if score(item) <= score(items[lowest]) {
+			lowest = i
+		}
+	}
+	p.long.VectorMemorys = append(items[:lowest:lowest], items[lowest+1:]...)
+	return true
+}
+
+// 长期记忆的最终排序得分 重排序后为重排序得分 否则为检索的综合得分
+// 同一次检索的记忆使用同一种得分 不同得分的取值范围不同
+func rankScore(items []model.LongMemoryItem) func(model.LongMemoryItem) float32 {
+	for _, item := range items {
+		if item.RerankScore != 0 {
+			return func(item model.LongMemoryItem) float32 { return item.RerankScore }
+		}
+	}
+	for _, item := range items {
+		if item.Score != 0 {
+			return func(item model.LongMemoryItem) float32 { return item.Score }
+		}
+	}
+	return func(item model.LongMemoryItem) float32 { return item.Similary }
+}
+
+// 丢弃最早的一条短期记忆
+func (p *promptSections) dropShort() bool {
+	if len(p.short.Memorys) == 0 {
+		return false
+	}
+	p.short.Memorys = p.short.Memorys[1:]
+	return true
+}
+
+// 二分查找满足 fits 的最长前缀
+func truncateText(text string, fits func(string) bool) string {
+	runes := []rune(text)
+	low, high := 0, len(runes)
+	for low < high {
+		mid := (low + high + 1) / 2
+		if fits(string(runes[:mid])) {
+			low = mid
+		} else {
+			high = mid - 1
+		}
+	}
+	ret := string(runes[:low])
+	fits(ret)
+	return ret
+}
@@ -2,8 +2,6 @@ package memory
 
 import (
 	"context"
-	"fmt"
-
 	"sync"
 	"time"
 
@@ -19,17 +17,21 @@ import (
 // 用于管理记忆上下文，及智能体所处的环境,总结,对内容理解提供一个大致的方向性
 type ContextMemoryHandler struct {
 	config     *config.ContextMemoryConfig
-	llmHandler *llm.LLM
+	llmHandler llm.ChatModel
 	sqlHandler *sqldb.SqlHandler
-	mu         sync.Mutex     // 用来保证SummaryMemoryContext函数的串行
+	templates  *prompt.Templates
+	timeouts   *config.TimeoutConfig
+	locks      keyedMutex     // 用来保证同一会话的SummaryMemoryContext串行 不同会话互不阻塞
 	wg         sync.WaitGroup // 用来等待所有任务完成
 }
 
-func NewContextMemoryHandler(config *config.ContextMemoryConfig, sqlHander *sqldb.SqlHandler, llm *llm.LLM) *ContextMemoryHandler {
+func NewContextMemoryHandler(config *config.ContextMemoryConfig, sqlHander *sqldb.SqlHandler, chatModel llm.ChatModel, templates *prompt.Templates, timeouts *config.TimeoutConfig) *ContextMemoryHandler {
 	return &ContextMemoryHandler{
 		sqlHandler: sqlHander,
-		llmHandler: llm,
+		llmHandler: chatModel,
 		config:     config,
+		templates:  templates,
+		timeouts:   timeouts,
 	}
 }
 
@@ -37,9 +39,9 @@ func (m *ContextMemoryHandler) WaitDone() {
 	m.wg.Wait()
 }
 
-// 返回获得的上下文记忆
-func (m *ContextMemoryHandler) GetContextMemory() (*model.ContextMemory, error) {
-	contextMemory, err := m.sqlHandler.GetLastContextMemory()
+// 返回会话的上下文记忆
+func (m *ContextMemoryHandler) GetContextMemory(ctx context.Context, userID, sessionID string) (*model.ContextMemory, error) {
+	contextMemory, err := m.sqlHandler.WithContext(ctx).GetLastContextMemory(userID, sessionID)
 	if err != nil {
 		return nil, err
 	}
@@ -47,12 +49,13 @@ func (m *ContextMemoryHandler) GetContextMemory() (*model.ContextMemory, error)
 }
 
 // 异步总结记忆上下文 避免阻塞记忆主线程
-func (m *ContextMemoryHandler) UpdateContextMemory() {
+// 后台执行不跟随调用方的ctx 只受 TIMEOUT.SUMMARY 限制
+func (m *ContextMemoryHandler) UpdateContextMemory(userID, sessionID string) {
 	// 等待
 	m.wg.Add(1)
 	go func() {
 		defer m.wg.Done()
-		err := m.SummaryContextMemory()
+		err := m.SummaryContextMemory(context.Background(), userID, sessionID)
 		if err != nil {
 			logrus.Errorf("SummaryContextMemory error: %v", err)
 		}
@@ -61,19 +64,21 @@ func (m *ContextMemoryHandler) UpdateContextMemory() {
 
 // 这个函数需要加锁串行 如果用户问的特别快 导致gap没有清0 导致问多次大模型,总结多次, 最新的summary 可能被老的覆盖掉
 // 立即总结记忆上下文
-func (m *ContextMemoryHandler) SummaryContextMemory() error {
-	// 加锁
-	m.mu.Lock()
-	defer m.mu.Unlock()
+func (m *ContextMemoryHandler) SummaryContextMemory(ctx context.Context, userID, sessionID string) error {
+	// 按会话加锁
+	unlock := m.locks.Lock(sessionKey(userID, sessionID))
+	defer unlock()
+	ctx, cancel := withTimeout(ctx, m.timeouts.Summary)
+	defer cancel()
 
 	// 获取上下文记忆
-	contextMemory, err := m.sqlHandler.GetLastContextMemory()
+	contextMemory, err := m.sqlHandler.GetLastContextMemory(userID, sessionID)
 	if err != nil {
 		return err
 	}
 
 	// 获取未总结的记忆数量
-	count, err := m.sqlHandler.GetUnSummarizedMemoryCount(contextMemory.LastSummaryID)
+	count, err := m.sqlHandler.GetUnSummarizedMemoryCount(userID, sessionID, contextMemory.LastSummaryID)
 	if err != nil {
 		return err
 	}
@@ -84,7 +89,7 @@ func (m *ContextMemoryHandler) SummaryContextMemory() error {
 	}
 
 	// 如果大于了gap值则一次性进行总结 总结过程中 如果用户继续提问 会被阻塞 可以支持并发 如果程序挂断 重启后正常进行总结
-	originalMemories, findCount, err := m.sqlHandler.GetLastOriginalMemory(int(count))
+	originalMemories, findCount, err := m.sqlHandler.GetLastOriginalMemory(userID, sessionID, int(count))
 	if err != nil {
 		return err
 	}
@@ -94,27 +99,31 @@ func (m *ContextMemoryHandler) SummaryContextMemory() error {
 		return nil
 	}
 
-	content := "#已总结内容: \n" + contextMemory.Summary
-	content += "\n#待总结对话: \n"
-
 	lastSummaryId := originalMemories[len(originalMemories)-1].ID
+	data := prompt.SummaryInputData{Summary: contextMemory.Summary}
+	contents := make([]string, 0, len(originalMemories))
 	for _, v := range originalMemories {
-		content += fmt.Sprintf("%v:%v\n", v.Role, v.Content)
+		data.Turns = append(data.Turns, v.Turn())
+		contents = append(contents, v.Content)
 	}
+	// 按对话内容选择模板语言
+	templates := m.templates.Detect(contents...)
 
 	messages := []openai.ChatCompletionMessage{
 		{
 			Role:    "system",
-			Content: prompt.CONTEXT_MEMORY_SUMMARY_PROMPT,
+			Content: templates.System(prompt.ContextSummaryTemplate),
 		},
 		{
 			Role:    "user",
-			Content: content,
+			Content: templates.Render(prompt.SummaryInputTemplate, data),
 		},
 	}
 
 	// 使用大模型总结记忆
-	summary, err := m.llmHandler.Chat(context.Background(), messages)
+	chatCtx, cancelChat := withTimeout(ctx, m.timeouts.LLM)
+	defer cancelChat()
+	summary, err := m.llmHandler.Chat(chatCtx, messages)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,64 @@
+package memory
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/xuanlv2002/miniMem0/llm/llmtest"
+)
+
+func TestSummaryContextMemory(t *testing.T) {
+	chat := llmtest.NewScriptedChatModel()
+	m := newTestMemorySystem(t, chat)
+	ctx := context.Background()
+
+	// 未达到 SUMMARY_GAP 时不总结
+	if _, err := m.ProcessInput(testUser, testSession, "我叫小明"); err != nil {
+		t.Fatalf("ProcessInput: %v", err)
+	}
+	if err := m.ContextMemoryHandler.SummaryContextMemory(ctx, testUser, testSession); err != nil {
+		t.Fatalf("SummaryContextMemory: %v", err)
+	}
+	if len(chat.Calls()) != 0 {
+		t.Fatalf("got %d model calls before the gap, want 0", len(chat.Calls()))
+	}
+
+	if err := m.saveOutput(ctx, testUser, testSession, "你好小明"); err != nil {
+		t.Fatalf("saveOutput: %v", err)
+	}
+	chat.Push(llmtest.Response{Content: "用户叫小明"})
+	if err := m.ContextMemoryHandler.SummaryContextMemory(ctx, testUser, testSession); err != nil {
+		t.Fatalf("SummaryContextMemory: %v", err)
+	}
+	contextMemory, err := m.GetContextMemory(testUser, testSession)
+	if err != nil {
+		t.Fatalf("GetContextMemory: %v", err)
+	}
+	if contextMemory.Summary != "用户叫小明" || contextMemory.LastSummaryID == 0 {
+		t.Fatalf("got context memory %+v, want the summary saved", contextMemory)
+	}
+	input := chat.Calls()[0].Messages[1].Content
+	if !strings.Contains(input, "我叫小明") || !strings.Contains(input, "你好小明") {
+		t.Fatalf("summary input %q misses the conversation", input)
+	}
+
+	// 再次总结时带上已有的总结 只总结新的对话
+	addTurn(t, m, "我住在上海", "上海是个好地方")
+	chat.Push(llmtest.Response{Content: "用户叫小明 住在上海"})
+	if err := m.ContextMemoryHandler.SummaryContextMemory(ctx, testUser, testSession); err != nil {
+		t.Fatalf("SummaryContextMemory: %v", err)
+	}
+	input = chat.Calls()[1].Messages[1].Content
+	if !strings.Contains(input, "用户叫小明") || !strings.Contains(input, "我住在上海") || strings.Contains(input, "你好小明") {
+		t.Fatalf("summary input %q, want the previous summary and only the new turns", input)
+	}
+	last := contextMemory.LastSummaryID
+	contextMemory, err = m.GetContextMemory(testUser, testSession)
+	if err != nil {
+		t.Fatalf("GetContextMemory: %v", err)
+	}
+	if contextMemory.Summary != "用户叫小明 住在上海" || contextMemory.LastSummaryID <= last {
+		t.Fatalf("got context memory %+v, want the new summary saved", contextMemory)
+	}
+}
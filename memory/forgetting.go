@@ -0,0 +1,199 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/philippgille/chromem-go"
+	"github.com/sirupsen/logrus"
+	"github.com/xuanlv2002/miniMem0/model"
+	"github.com/xuanlv2002/miniMem0/tokenizer"
+)
+
+/*
+	长期记忆的容量控制
+	抽取时由大模型评估每条事实的重要性 程序把它写入对应记忆的元数据 记忆每次被检索返回时记录使用次数和时间
+	用户的记忆超出 MAX_MEMORIES_PER_USER 时 淘汰价值最低的记忆
+	记忆的价值 = 重要性 × 0.5^(距最近使用的时间/半衰期) × (1+ln(1+使用次数))
+	淘汰会记录到变更记录中 可以通过 Rollback 恢复
+*/
+
+const (
+	EvictionDelete  = "delete"  // 删除记忆
+	EvictionArchive = "archive" // 移入归档集合 不参与检索
+)
+
+// 记录检索返回的记忆 失败时只记录警告 不影响检索
+func (l *LongMemoryHandler) touchMemories(userID string, items []model.LongMemoryItem) {
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.ID)
+	}
+	if err := l.sqlHandler.TouchMemories(userID, ids, time.Now()); err != nil {
+		logrus.Warnf("failed to record memory access: %v", err)
+	}
+}
+
+// 用户的记忆超出容量时淘汰价值最低的记忆 需要持有长期记忆锁
+func (l *LongMemoryHandler) enforceCapacity(ctx context.Context, source memorySource, userID string) error {
+	if l.config.MaxMemories <= 0 {
+		return nil
+	}
+	memories, err := l.vector.List(ctx, userFilter(userID))
+	if err != nil {
+		return err
+	}
+	over := len(memories) - l.config.MaxMemories
+	if over <= 0 {
+		return nil
+	}
+	stats, err := l.sqlHandler.GetMemoryStats(userID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	utilities := make(map[string]float64, len(memories))
+	for _, memory := range memories {
+		utilities[memory.ID] = memoryUtility(memory.Metadata, stats[memory.ID], now, l.config.ForgetHalfLife)
+	}
+	slices.SortStableFunc(memories, func(a, b chromem.Result) int {
+		switch {
+		case utilities[a.ID] < utilities[b.ID]:
+			return -1
+		case utilities[a.ID] > utilities[b.ID]:
+			return 1
+		}
+		return 0
+	})
+
+	for _, memory := range memories[:over] {
+		evictSource := source
+		evictSource.Reason = fmt.Sprintf("evicted by %s, utility %.4f", l.eviction(), utilities[memory.ID])
+		if err := l.evictMemory(ctx, evictSource, userID, memory.ID); err != nil {
+			return fmt.Errorf("failed to evict memory %s: %v", memory.ID, err)
+		}
+		logrus.Infof("Evicted memory %s: %s, utility %.4f", memory.ID, memory.Content, utilities[memory.ID])
+	}
+	return nil
+}
+
+// 淘汰一条记忆 归档时先把记忆连同向量移入归档集合 变更记录中均记为 DELETE
+func (l *LongMemoryHandler) evictMemory(ctx context.Context, source memorySource, userID, memoryID string) error {
+	if l.eviction() != EvictionArchive {
+		_, err := l.deleteMemory(ctx, source, userID, memoryID)
+		return err
+	}
+	old, err := l.checkOwner(ctx, userID, memoryID)
+	if err != nil {
+		return err
+	}
+	version, err := l.nextVersion(userID, memoryID)
+	if err != nil {
+		return err
+	}
+	err = l.recordHistory(source, userID, memoryID, "DELETE", version, old, "", nil, func() error {
+		return l.vector.Archive(ctx, []chromem.Document{*old})
+	})
+	if err != nil {
+		return err
+	}
+	l.deleteStats(userID, memoryID)
+	return nil
+}
+
+// 淘汰方式 未配置时删除
+func (l *LongMemoryHandler) eviction() string {
+	if l.config.Eviction == "" {
+		return EvictionDelete
+	}
+	return l.config.Eviction
+}
+
+// 列出用户被归档的记忆
+func (l *LongMemoryHandler) ListArchivedMemory(ctx context.Context, userID string) ([]model.LongMemoryItem, error) {
+	ret, err := l.vector.ListArchived(ctx, userFilter(userID))
+	if err != nil {
+		return nil, err
+	}
+	items := make([]model.LongMemoryItem, 0, len(ret))
+	for _, v := range ret {
+		items = append(items, model.LongMemoryItem{
+			ID:   v.ID,
+			Text: v.Content,
+			Meta: v.Metadata,
+		})
+	}
+	return items, nil
+}
+
+// 记忆操作对应事实的重要性 模型不输出重要性 按内容找到最相近的事实
+// 内容相同时直接使用 否则取关键词重合度(Dice)最高的事实 只有一条事实时就是它
+// 找不到对应的事实时返回0 记忆不写入重要性
+func factImportance(facts []model.Fact, text string) int {
+	if len(facts) == 1 {
+		return facts[0].Importance
+	}
+	words := keywordSet(text)
+	best, importance := 0.0, 0
+	for _, fact := range facts {
+		if fact.Content == text {
+			return fact.Importance
+		}
+		factWords := keywordSet(fact.Content)
+		if len(words)+len(factWords) == 0 {
+			continue
+		}
+		common := 0
+		for word := range factWords {
+			if words[word] {
+				common++
+			}
+		}
+		score := 2 * float64(common) / float64(len(words)+len(factWords))
+		if score > best || (score == best && score > 0 && fact.Importance > importance) {
+			best, importance = score, fact.Importance
+		}
+	}
+	return importance
+}
+
+// 文本中不重复的关键词
+func keywordSet(text string) map[string]bool {
+	ret := make(map[string]bool)
+	for _, word := range tokenizer.Keywords(text) {
+		ret[word] = true
+	}
+	return ret
+}
+
+// 记忆的价值 重要性取 1~10 缺失时为 DefaultImportance
+// 新旧按最近一次被检索返回的时间计算 从未被返回时(stat 为零值)按修改时间
+func memoryUtility(metadata map[string]string, stat model.MemoryStat, now time.Time, halfLife time.Duration) float64 {
+	importance, err := strconv.Atoi(metadata[model.MetaImportance])
+	if err != nil {
+		importance = model.DefaultImportance
+	}
+	importance = min(max(importance, 1), 10)
+	utility := float64(importance) / 10
+
+	if halfLife > 0 {
+		var last time.Time
+		if stat.LastAccessedAt != nil {
+			last = *stat.LastAccessedAt
+		} else if t, err := time.Parse(time.RFC3339, metadata[model.MetaUpdatedAt]); err == nil {
+			last = t
+		} else if t, err := time.Parse(time.RFC3339, metadata[model.MetaCreatedAt]); err == nil {
+			last = t
+		}
+		if !last.IsZero() && now.After(last) {
+			utility *= math.Pow(0.5, float64(now.Sub(last))/float64(halfLife))
+		}
+	}
+
+	return utility * (1 + math.Log1p(float64(stat.AccessCount)))
+}
@@ -0,0 +1,166 @@
+package memory
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/xuanlv2002/miniMem0/config"
+	"github.com/xuanlv2002/miniMem0/llm/llmtest"
+	"github.com/xuanlv2002/miniMem0/model"
+)
+
+func TestFactImportance(t *testing.T) {
+	facts := []model.Fact{
+		{Content: "我叫小明", Importance: 8},
+		{Content: "明天下午去看电影", Importance: 2},
+	}
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"我叫小明", 8},
+		{"用户叫小明", 8},
+		{"明天下午要去看电影", 2},
+		{"喜欢游泳", 0},
+	}
+	for _, tt := range tests {
+		if got := factImportance(facts, tt.text); got != tt.want {
+			t.Errorf("factImportance(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestImportanceStampedFromFacts(t *testing.T) {
+	chat := llmtest.NewScriptedChatModel()
+	m := newTestMemorySystem(t, chat)
+	ctx := context.Background()
+
+	addTurn(t, m, "我叫小明 明天下午去看电影", "好的")
+	chat.Push(
+		llmtest.Response{Content: factsResponse(t,
+			model.Fact{Content: "我叫小明", About: "user", Importance: 8},
+			model.Fact{Content: "明天下午去看电影", About: "user", Importance: 2},
+		)},
+		// 模型写入的 importance 不生效
+		llmtest.Response{Content: memoryResponse(t,
+			model.MemoryEvent{Text: "我叫小明", Event: "ADD", Meta: map[string]string{"importance": "1"}},
+			model.MemoryEvent{Text: "明天下午要去看电影", Event: "ADD"},
+		)},
+	)
+	if _, err := m.LongMemoryHandler.SaveLongMemory(ctx, testUser, testSession); err != nil {
+		t.Fatalf("SaveLongMemory: %v", err)
+	}
+	name := findMemory(t, m, "我叫小明")
+	movie := findMemory(t, m, "明天下午要去看电影")
+	if name == nil || movie == nil {
+		t.Fatal("memories not saved")
+	}
+	if got := name.Meta[model.MetaImportance]; got != "8" {
+		t.Errorf("name importance = %q, want 8", got)
+	}
+	if got := movie.Meta[model.MetaImportance]; got != "2" {
+		t.Errorf("movie importance = %q, want 2", got)
+	}
+
+	// UPDATE 取新旧重要性中较高的值
+	addTurn(t, m, "其实电影改到后天了", "好的")
+	chat.Handler = func(messages []openai.ChatCompletionMessage) llmtest.Response {
+		input := messages[len(messages)-1].Content
+		if !strings.Contains(input, "#可能相关的记忆") {
+			return llmtest.Response{Content: factsResponse(t,
+				model.Fact{Content: "电影改到后天下午", About: "user", Importance: 3},
+			)}
+		}
+		var alias string
+		for _, line := range strings.Split(input, "\n") {
+			if strings.Contains(line, "明天下午要去看电影") {
+				alias = memoryAlias(line)
+			}
+		}
+		return llmtest.Response{Content: memoryResponse(t,
+			model.MemoryEvent{ID: alias, Text: "后天下午去看电影", Event: "UPDATE"},
+		)}
+	}
+	if _, err := m.LongMemoryHandler.SaveLongMemory(ctx, testUser, testSession); err != nil {
+		t.Fatalf("SaveLongMemory: %v", err)
+	}
+	movie = findMemory(t, m, "后天下午去看电影")
+	if movie == nil {
+		t.Fatal("memory not updated")
+	}
+	if got := movie.Meta[model.MetaImportance]; got != "3" {
+		t.Errorf("updated importance = %q, want 3", got)
+	}
+}
+
+func TestEvictionDeletesStats(t *testing.T) {
+	chat := llmtest.NewScriptedChatModel()
+	m := newTestMemorySystem(t, chat, func(cfg *config.Config) {
+		cfg.LongMemoryConfig.MaxMemories = 2
+		cfg.LongMemoryConfig.Eviction = EvictionArchive
+	})
+	handler := m.LongMemoryHandler
+	ctx := context.Background()
+
+	addTurn(t, m, "我叫小明 明天下午去看电影", "好的")
+	chat.Push(
+		llmtest.Response{Content: factsResponse(t,
+			model.Fact{Content: "我叫小明", About: "user", Importance: 8},
+			model.Fact{Content: "明天下午去看电影", About: "user", Importance: 2},
+		)},
+		llmtest.Response{Content: memoryResponse(t,
+			model.MemoryEvent{Text: "我叫小明", Event: "ADD"},
+			model.MemoryEvent{Text: "明天下午去看电影", Event: "ADD"},
+		)},
+	)
+	if _, err := handler.SaveLongMemory(ctx, testUser, testSession); err != nil {
+		t.Fatalf("SaveLongMemory: %v", err)
+	}
+	name := findMemory(t, m, "我叫小明")
+	movie := findMemory(t, m, "明天下午去看电影")
+	if name == nil || movie == nil {
+		t.Fatal("memories not saved")
+	}
+	if err := handler.sqlHandler.TouchMemories(testUser, []string{name.ID, movie.ID}, time.Now()); err != nil {
+		t.Fatalf("TouchMemories: %v", err)
+	}
+
+	// 超出容量 淘汰价值最低的记忆 它的使用情况一并删除
+	addTurn(t, m, "我对花生过敏", "记住了")
+	chat.Push(
+		llmtest.Response{Content: factsResponse(t,
+			model.Fact{Content: "对花生过敏", About: "user", Importance: 9},
+		)},
+		llmtest.Response{Content: memoryResponse(t,
+			model.MemoryEvent{Text: "对花生过敏", Event: "ADD"},
+		)},
+	)
+	if _, err := handler.SaveLongMemory(ctx, testUser, testSession); err != nil {
+		t.Fatalf("SaveLongMemory: %v", err)
+	}
+	if findMemory(t, m, "明天下午去看电影") != nil {
+		t.Fatal("want the least valuable memory evicted")
+	}
+	stats, err := handler.sqlHandler.GetMemoryStats(testUser)
+	if err != nil {
+		t.Fatalf("GetMemoryStats: %v", err)
+	}
+	if _, ok := stats[movie.ID]; ok || len(stats) != 1 {
+		t.Fatalf("got stats %+v, want only %s", stats, name.ID)
+	}
+
+	// 删除记忆时同样删除使用情况
+	if err := m.DeleteLongMemory(testUser, name.ID); err != nil {
+		t.Fatalf("DeleteLongMemory: %v", err)
+	}
+	stats, err = handler.sqlHandler.GetMemoryStats(testUser)
+	if err != nil {
+		t.Fatalf("GetMemoryStats: %v", err)
+	}
+	if len(stats) != 0 {
+		t.Fatalf("got stats %+v, want none", stats)
+	}
+}
@@ -0,0 +1,243 @@
+package memory
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/sirupsen/logrus"
+	"github.com/xuanlv2002/miniMem0/db/sqldb"
+	"github.com/xuanlv2002/miniMem0/model"
+	"github.com/xuanlv2002/miniMem0/prompt"
+	"github.com/xuanlv2002/miniMem0/tokenizer"
+)
+
+/*
+	关系记忆
+	向量记忆按语义检索单条事实 关系记忆把人物 地点 事物等实体和它们之间的关系存储在SQL数据库中
+	抽取长期记忆时 由大模型从新事实中抽取实体和关系 并删除被新事实推翻的关系
+	检索时找到输入中提到的实体 展开与它们相连的关系
+*/
+
+// 一次抽取得到的关系变更 在写入长期记忆之前得到 保存在写入计划中
+type graphUpdate struct {
+	Entities []struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+	} `json:"entities"`
+	Relations []struct {
+		Source   string `json:"source"`
+		Relation string `json:"relation"`
+		Target   string `json:"target"`
+	} `json:"relations"`
+	Delete []string `json:"delete"` // 已有关系的临时编号
+	// 临时编号对应的关系ID 不由模型返回
+	DeleteIDs []int64 `json:"delete_ids,omitempty"`
+}
+
+// 从新事实中抽取实体和关系 已有关系使用临时编号代替真实ID
+func (l *LongMemoryHandler) extractGraph(ctx context.Context, templates *prompt.Templates, userID string, facts []model.Fact) (*graphUpdate, error) {
+	texts := make([]string, 0, len(facts)*2)
+	for _, fact := range facts {
+		texts = append(texts, fact.Content, fact.About)
+	}
+	relations, err := l.findRelations(userID, texts)
+	if err != nil {
+		return nil, err
+	}
+
+	var data prompt.GraphInputData
+	for _, fact := range facts {
+		data.Facts = append(data.Facts, prompt.Fact{
+			Content:    fact.Content,
+			AppearTime: fact.AppearTime,
+			About:      fact.About,
+		})
+	}
+	aliases := make(map[string]int64, len(relations))
+	for i, relation := range relations {
+		alias := strconv.Itoa(i + 1)
+		aliases[alias] = relation.ID
+		item := relation.Item()
+		data.Relations = append(data.Relations, prompt.GraphRelation{
+			ID:       alias,
+			Source:   item.Source,
+			Relation: item.Relation,
+			Target:   item.Target,
+		})
+	}
+
+	var update graphUpdate
+	err = l.chatJSON(ctx, templates, []openai.ChatCompletionMessage{
+		{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: templates.System(prompt.GraphExtractionTemplate),
+		},
+		{
+			Role:    openai.ChatMessageRoleUser,
+			Content: templates.Render(prompt.GraphInputTemplate, data),
+		},
+	}, graphSchema, &update)
+	if err != nil {
+		return nil, err
+	}
+	update.DeleteIDs = nil
+	for _, alias := range update.Delete {
+		id, ok := aliases[alias]
+		if !ok {
+			logrus.Warnf("rejected relation delete %q: not in the existing relations", alias)
+			continue
+		}
+		update.DeleteIDs = append(update.DeleteIDs, id)
+	}
+	return &update, nil
+}
+
+// 写入抽取得到的实体和关系 先删除被推翻的关系再添加新关系 tx 为写入使用的事务
+func (l *LongMemoryHandler) applyGraph(tx *sqldb.SqlHandler, userID string, runID int64, update *graphUpdate) error {
+	if err := tx.DeleteRelations(userID, runID, update.DeleteIDs); err != nil {
+		return err
+	}
+	entities := make(map[string]*model.Entity)
+	saveEntity := func(name, entityType string) (*model.Entity, error) {
+		if entity, ok := entities[name]; ok && entityType == "" {
+			return entity, nil
+		}
+		entity, err := tx.SaveEntity(userID, name, entityType)
+		if err != nil {
+			return nil, err
+		}
+		entities[name] = entity
+		return entity, nil
+	}
+	for _, entity := range update.Entities {
+		name := strings.TrimSpace(entity.Name)
+		if name == "" {
+			continue
+		}
+		if _, err := saveEntity(name, strings.TrimSpace(entity.Type)); err != nil {
+			return err
+		}
+	}
+	for _, relation := range update.Relations {
+		sourceName := strings.TrimSpace(relation.Source)
+		targetName := strings.TrimSpace(relation.Target)
+		relationType := strings.TrimSpace(relation.Relation)
+		if sourceName == "" || targetName == "" || relationType == "" {
+			logrus.Warnf("rejected relation %s -%s-> %s: empty field", relation.Source, relation.Relation, relation.Target)
+			continue
+		}
+		// 关系中出现但没有在 entities 中声明的实体 以空类型创建
+		source, err := saveEntity(sourceName, "")
+		if err != nil {
+			return err
+		}
+		target, err := saveEntity(targetName, "")
+		if err != nil {
+			return err
+		}
+		err = tx.AddRelation(&model.Relation{
+			UserID:          userID,
+			SourceID:        source.ID,
+			TargetID:        target.ID,
+			Type:            relationType,
+			ExtractionRunID: runID,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	logrus.Infof("Saved %d relations, deleted %d relations", len(update.Relations), len(update.DeleteIDs))
+	return nil
+}
+
+// 获得文本中提到的实体相连的关系 最多返回 MAX_RELATIONS 条
+func (l *LongMemoryHandler) findRelations(userID string, texts []string) ([]model.Relation, error) {
+	entities, err := l.sqlHandler.GetEntities(userID)
+	if err != nil {
+		return nil, err
+	}
+	ids := matchEntities(entities, texts)
+	return l.sqlHandler.GetRelations(userID, ids, l.graphConfig.MaxRelations)
+}
+
+// 检索文本中提到的实体的关系记忆
+func (l *LongMemoryHandler) searchRelations(userID string, texts []string) ([]model.RelationItem, error) {
+	relations, err := l.findRelations(userID, texts)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]model.RelationItem, 0, len(relations))
+	for _, relation := range relations {
+		items = append(items, relation.Item())
+	}
+	return items, nil
+}
+
+// 列出用户的所有关系记忆
+func (l *LongMemoryHandler) ListRelations(ctx context.Context, userID string) ([]model.Relation, error) {
+	return l.sqlHandler.WithContext(ctx).ListRelations(userID)
+}
+
+const (
+	userEntityName     = "user" // 用户本人的实体名称 与抽取提示词一致
+	minEntityNameRunes = 2      // 参与匹配的实体名称的最少字数 单字在中文里几乎总能匹配到
+)
+
+// 找到文本中提到的实体 忽略大小写 用户本人的实体总是展开
+// 输入中的 "我" "I" 等指代用户本人 无法按名称匹配
+func matchEntities(entities []model.Entity, texts []string) []int64 {
+	lower := make([]string, 0, len(texts))
+	for _, text := range texts {
+		lower = append(lower, strings.ToLower(text))
+	}
+	var ids []int64
+	for _, entity := range entities {
+		name := strings.ToLower(strings.TrimSpace(entity.Name))
+		if name == userEntityName {
+			ids = append(ids, entity.ID)
+			continue
+		}
+		if utf8.RuneCountInString(name) < minEntityNameRunes {
+			continue
+		}
+		for _, text := range lower {
+			if containsName(text, name) {
+				ids = append(ids, entity.ID)
+				break
+			}
+		}
+	}
+	return ids
+}
+
+// text 中是否在词的边界上出现 name 名称首尾为字母或数字时 前后不能紧接字母或数字
+// 避免 "Al" 匹配 "also" 中日韩文字没有分隔 只要求出现
+func containsName(text, name string) bool {
+	for i := 0; i < len(text); {
+		j := strings.Index(text[i:], name)
+		if j < 0 {
+			return false
+		}
+		start, end := i+j, i+j+len(name)
+		first, _ := utf8.DecodeRuneInString(name)
+		last, _ := utf8.DecodeLastRuneInString(name)
+		before, _ := utf8.DecodeLastRuneInString(text[:start])
+		after, _ := utf8.DecodeRuneInString(text[end:])
+		if !(isWordRune(first) && start > 0 && isWordRune(before)) &&
+			!(isWordRune(last) && end < len(text) && isWordRune(after)) {
+			return true
+		}
+		_, size := utf8.DecodeRuneInString(text[start:])
+		i = start + size
+	}
+	return false
+}
+
+// 是否是需要按空格等分隔的字母或数字 中日韩文字除外
+func isWordRune(r rune) bool {
+	return (unicode.IsLetter(r) || unicode.IsDigit(r)) && !tokenizer.IsCJK(r)
+}
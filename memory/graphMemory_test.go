@@ -0,0 +1,36 @@
+package memory
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/xuanlv2002/miniMem0/model"
+)
+
+func TestMatchEntities(t *testing.T) {
+	entities := []model.Entity{
+		{ID: 1, Name: "user"},
+		{ID: 2, Name: "约翰"},
+		{ID: 3, Name: "猫"},
+		{ID: 4, Name: "Al"},
+		{ID: 5, Name: "Go"},
+	}
+	tests := []struct {
+		text string
+		want []int64
+	}{
+		{"我喜欢什么", []int64{1}},
+		{"what do I like", []int64{1}},
+		{"约翰住在哪里", []int64{1, 2}},
+		{"我的猫叫什么", []int64{1}},
+		{"I also like going out", []int64{1}},
+		{"Al likes Go.", []int64{1, 4, 5}},
+		{"和al一起", []int64{1, 4}},
+	}
+	for _, tt := range tests {
+		got := matchEntities(entities, []string{tt.text})
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("matchEntities(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
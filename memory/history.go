@@ -0,0 +1,283 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"time"
+
+	"github.com/philippgille/chromem-go"
+	"github.com/sirupsen/logrus"
+	"github.com/xuanlv2002/miniMem0/model"
+)
+
+/*
+	长期记忆的变更记录和撤销
+	每次 ADD/UPDATE/DELETE 都会记录变更前后的内容 可以把一条记忆回滚到任意版本
+	或者撤销一次抽取产生的所有变更
+*/
+
+// 记忆变更的来源 手动操作时为零值
+type memorySource struct {
+	SessionID  string
+	StartID    int64 // 来源对话 OriginalMemory 的ID范围
+	EndID      int64
+	MessageIDs []int64 // 来源对话 OriginalMemory 的ID
+	RunID      int64   // 抽取批次
+	Reason     string  // 手动操作的说明
+}
+
+// 获得用户某条长期记忆的变更记录 按时间从早到晚排序
+func (l *LongMemoryHandler) History(ctx context.Context, userID, memoryID string) ([]model.MemoryHistory, error) {
+	return l.sqlHandler.WithContext(ctx).GetMemoryHistory(userID, memoryID)
+}
+
+// 列出用户的长期记忆抽取 sessionID 为空时列出所有会话
+func (l *LongMemoryHandler) ListExtractionRuns(ctx context.Context, userID, sessionID string) ([]model.ExtractionRun, error) {
+	return l.sqlHandler.WithContext(ctx).ListExtractionRuns(userID, sessionID)
+}
+
+// 把记忆回滚到指定版本变更后的状态 回滚本身也会记录为一次变更
+// 目标版本为 DELETE 时删除记忆
+func (l *LongMemoryHandler) Rollback(ctx context.Context, userID, memoryID string, toVersion int) error {
+	unlock := l.userLocks.Lock(userID)
+	defer unlock()
+	history, err := l.sqlHandler.GetMemoryHistory(userID, memoryID)
+	if err != nil {
+		return err
+	}
+	if len(history) == 0 {
+		return fmt.Errorf("memory %s has no history", memoryID)
+	}
+	var target *model.MemoryHistory
+	for i := range history {
+		if history[i].Version == toVersion {
+			target = &history[i]
+		}
+	}
+	if target == nil {
+		return fmt.Errorf("memory %s has no version %d", memoryID, toVersion)
+	}
+	last := &history[len(history)-1]
+	if last.Version == toVersion {
+		return nil
+	}
+	source := memorySource{Reason: fmt.Sprintf("rollback to version %d", toVersion)}
+	return l.restoreMemory(ctx, source, userID, memoryID, last, target.Event != "DELETE", target.NewText, target.NewMeta)
+}
+
+// 撤销一次抽取的所有变更 把涉及的记忆和关系恢复到抽取前的状态
+// 涉及的记忆在抽取之后又被修改过时拒绝撤销 需要先撤销之后的变更
+// resetCursor 为真时把会话的抽取进度退回到抽取前 之后的抽取任务会重新抽取这段对话
+func (l *LongMemoryHandler) RevertExtraction(ctx context.Context, userID string, runID int64, resetCursor bool) (*model.ExtractionRun, error) {
+	run, err := l.sqlHandler.GetExtractionRun(userID, runID)
+	if err != nil {
+		return nil, err
+	}
+	// 会修改会话的抽取进度和用户的记忆 与抽取相同先锁会话再锁用户 加锁后重新读取
+	unlockSession := l.sessionLocks.Lock(sessionKey(userID, run.SessionID))
+	defer unlockSession()
+	unlockUser := l.userLocks.Lock(userID)
+	defer unlockUser()
+	run, err = l.sqlHandler.GetExtractionRun(userID, runID)
+	if err != nil {
+		return nil, err
+	}
+	if run.RevertedAt != nil {
+		return nil, fmt.Errorf("extraction run %d has already been reverted", runID)
+	}
+	changes, err := l.sqlHandler.GetRunHistory(runID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 每条记忆抽取前的状态在它本次的第一条变更中 抽取后的状态在最后一条变更中
+	var order []string
+	first := make(map[string]*model.MemoryHistory)
+	after := make(map[string]*model.MemoryHistory)
+	for i := range changes {
+		memoryID := changes[i].MemoryID
+		if _, ok := first[memoryID]; !ok {
+			first[memoryID] = &changes[i]
+			order = append(order, memoryID)
+		}
+		after[memoryID] = &changes[i]
+	}
+	// 记忆当前的状态与抽取后的状态不同时 说明之后又被修改过
+	// 之后的变更已被撤销时状态相同 可以继续撤销
+	last := make(map[string]*model.MemoryHistory)
+	for _, memoryID := range order {
+		h, err := l.sqlHandler.GetLastMemoryHistory(userID, memoryID)
+		if err != nil {
+			return nil, err
+		}
+		if !sameState(h, after[memoryID]) {
+			return nil, fmt.Errorf("memory %s has been changed after extraction run %d", memoryID, runID)
+		}
+		last[memoryID] = h
+	}
+
+	// 写入未完成的抽取没有更新过抽取进度 不需要退回
+	resetCursor = resetCursor && !run.Unfinished()
+	var longMemory *model.LongMemory
+	if resetCursor {
+		longMemory, err = l.sqlHandler.GetLastLongMemroy(userID, run.SessionID)
+		if err != nil {
+			return nil, err
+		}
+		if longMemory.LastExtractionID != run.SourceEndID {
+			return nil, fmt.Errorf("session %s has been extracted after extraction run %d", run.SessionID, runID)
+		}
+	}
+
+	// 逆序恢复
+	source := memorySource{Reason: fmt.Sprintf("revert extraction run %d", runID)}
+	for i := len(order) - 1; i >= 0; i-- {
+		memoryID := order[i]
+		before := first[memoryID]
+		err := l.restoreMemory(ctx, source, userID, memoryID, last[memoryID], before.Event != "ADD", before.OldText, before.OldMeta)
+		if err != nil {
+			return nil, fmt.Errorf("failed to restore memory %s: %v", memoryID, err)
+		}
+	}
+
+	// 删除本次抽取新增的关系 恢复本次抽取删除的关系
+	deleted, restored, err := l.sqlHandler.RevertRunRelations(userID, runID)
+	if err != nil {
+		return nil, err
+	}
+
+	if resetCursor {
+		longMemory.LastExtractionID = run.PrevExtractionID
+		longMemory.UpdatedAt = time.Now()
+		if err := l.sqlHandler.SaveLongMemoryLastExtractionID(longMemory); err != nil {
+			return nil, err
+		}
+	}
+	if err := l.sqlHandler.MarkExtractionRunReverted(run, time.Now()); err != nil {
+		return nil, err
+	}
+	logrus.Infof("Reverted extraction run %d, %d memories restored, %d relations deleted, %d relations restored", runID, len(order), deleted, restored)
+	return run, nil
+}
+
+// 把记忆恢复到指定状态 last 为记忆最新的变更记录 exists 为假时删除记忆
+func (l *LongMemoryHandler) restoreMemory(ctx context.Context, source memorySource, userID, memoryID string, last *model.MemoryHistory, exists bool, text string, metadata map[string]string) error {
+	var current *chromem.Document
+	if last != nil && last.Event != "DELETE" {
+		current = &chromem.Document{ID: memoryID, Content: last.NewText, Metadata: last.NewMeta}
+	}
+	version, err := l.nextVersion(userID, memoryID)
+	if err != nil {
+		return err
+	}
+	switch {
+	case exists:
+		// 恢复当时的元数据 只更新修改时间和版本等系统字段
+		metadata = l.stampMeta(userID, metadata, nil, source, version, 0)
+		event := "UPDATE"
+		if current == nil {
+			event = "ADD"
+		}
+		return l.recordHistory(source, userID, memoryID, event, version, current, text, metadata, func() error {
+			err := l.vector.Add(ctx, []chromem.Document{
+				{
+					ID:       memoryID,
+					Metadata: metadata,
+					Content:  text,
+				},
+			}, 1)
+			if err != nil || current != nil {
+				return err
+			}
+			// 被归档的记忆恢复后从归档集合中移除
+			return l.vector.DeleteArchived(ctx, []string{memoryID})
+		})
+	case current != nil:
+		return l.recordHistory(source, userID, memoryID, "DELETE", version, current, "", nil, func() error {
+			return l.vector.Delete(ctx, []string{memoryID})
+		})
+	}
+	return nil
+}
+
+// 两条变更记录后记忆的状态是否相同 不比较修改时间和版本等系统字段
+func sameState(a, b *model.MemoryHistory) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Event == "DELETE" || b.Event == "DELETE" {
+		return a.Event == b.Event
+	}
+	return a.NewText == b.NewText && maps.Equal(model.PromptMeta(a.NewMeta), model.PromptMeta(b.NewMeta))
+}
+
+// 记忆下一个版本号 在最新的变更记录的基础上加1 没有记录时为1
+func (l *LongMemoryHandler) nextVersion(userID, memoryID string) (int, error) {
+	last, err := l.sqlHandler.GetLastMemoryHistory(userID, memoryID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get history of memory %s: %v", memoryID, err)
+	}
+	if last == nil {
+		return 1, nil
+	}
+	return last.Version + 1, nil
+}
+
+// 记录一条记忆变更并执行 apply 先写入变更记录 apply 失败时删除这条记录 写入失败时返回错误 由调用方重试
+// 写入记录后进程退出时记录会保留而 apply 没有执行 抽取恢复时由 completeHistory 按记录补齐
+func (l *LongMemoryHandler) recordHistory(source memorySource, userID, memoryID, event string, version int, old *chromem.Document, newText string, newMeta map[string]string, apply func() error) error {
+	history := &model.MemoryHistory{
+		MemoryID:        memoryID,
+		UserID:          userID,
+		SessionID:       source.SessionID,
+		Version:         version,
+		Event:           event,
+		NewText:         newText,
+		NewMeta:         newMeta,
+		SourceStartID:   source.StartID,
+		SourceEndID:     source.EndID,
+		ExtractionRunID: source.RunID,
+		Reason:          source.Reason,
+	}
+	if old != nil {
+		history.OldText = old.Content
+		history.OldMeta = old.Metadata
+	}
+	if err := l.sqlHandler.AddMemoryHistory(history); err != nil {
+		return fmt.Errorf("failed to record history of memory %s: %v", memoryID, err)
+	}
+	if err := apply(); err != nil {
+		if err := l.sqlHandler.DeleteMemoryHistory(history.ID); err != nil {
+			logrus.Errorf("failed to remove history %d of memory %s: %v", history.ID, memoryID, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// 按变更记录补齐向量库 记录写入后进程可能在执行前退出
+// ADD/UPDATE 时记忆的内容和元数据与记录一致即已执行 DELETE 时记忆不存在即已执行
+func (l *LongMemoryHandler) completeHistory(ctx context.Context, history *model.MemoryHistory) error {
+	current := l.vector.Get(ctx, history.MemoryID)
+	if history.Event == "DELETE" {
+		if current == nil {
+			return nil
+		}
+		if err := l.vector.Delete(ctx, []string{history.MemoryID}); err != nil {
+			return err
+		}
+		l.deleteStats(history.UserID, history.MemoryID)
+		logrus.Warnf("Completed interrupted delete of memory %s", history.MemoryID)
+		return nil
+	}
+	if current != nil && current.Content == history.NewText && maps.Equal(current.Metadata, history.NewMeta) {
+		return nil
+	}
+	doc := chromem.Document{ID: history.MemoryID, Content: history.NewText, Metadata: history.NewMeta}
+	if err := l.vector.Add(ctx, []chromem.Document{doc}, 1); err != nil {
+		return err
+	}
+	logrus.Warnf("Completed interrupted %s of memory %s", history.Event, history.MemoryID)
+	return nil
+}
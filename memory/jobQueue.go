@@ -0,0 +1,384 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xuanlv2002/miniMem0/config"
+	"github.com/xuanlv2002/miniMem0/db/sqldb"
+	"github.com/xuanlv2002/miniMem0/model"
+)
+
+/*
+	后台记忆任务队列
+	上下文总结和长期记忆抽取以任务的形式持久化在SQL数据库中 由固定数量的worker执行
+	任务失败后按指数退避重试 超过最大尝试次数后标记为失败 可以手动重试
+	程序崩溃重启后 执行中的任务会恢复为等待中继续执行
+*/
+
+const (
+	defaultJobWorkers       = 2
+	defaultJobMaxAttempts   = 5
+	defaultJobRetryDelay    = 5   // 秒
+	defaultJobMaxRetryDelay = 600 // 秒
+	jobIdleInterval         = time.Minute
+)
+
+// 队列已停止时返回的错误
+var ErrJobQueueStopped = errors.New("memory job queue is stopped")
+
+// 执行一个会话的后台任务 返回长期记忆中实际执行的操作
+// 各阶段的超时由执行函数自行控制
+type JobHandler func(ctx context.Context, userID, sessionID string) ([]model.MemoryEvent, error)
+
+// 任务的最终结果 成功或超过最大尝试次数后产生 退避重试中的任务没有结果
+// 队列停止时 有等待者的未完成任务产生 ErrJobQueueStopped 结果
+type JobResult struct {
+	Job    model.MemoryJob
+	Events []model.MemoryEvent
+	Err    error
+}
+
+// 一个任务的等待者
+type jobWaiters struct {
+	job   model.MemoryJob
+	chans []chan JobResult
+}
+
+type JobQueue struct {
+	config     config.JobConfig
+	sqlHandler *sqldb.SqlHandler
+	handlers   map[string]JobHandler
+	claimMu    sync.Mutex    // 保证领取任务串行
+	wake       chan struct{} // 唤醒空闲的worker
+	stop       chan struct{}
+	ctx        context.Context // 执行任务的ctx Stop 时取消 中断执行中的任务
+	cancel     context.CancelFunc
+	stopOnce   sync.Once
+	stopped    bool
+	stateMu    sync.Mutex
+	changed    chan struct{} // 任务状态变化时关闭并替换 用于等待队列空闲
+	waiters    map[int64]*jobWaiters
+	onResult   func(JobResult) // 每个任务产生结果时回调
+	wg         sync.WaitGroup
+}
+
+// 新建任务队列 handlers 为每种任务类型的执行函数
+func NewJobQueue(cfg *config.JobConfig, sqlHandler *sqldb.SqlHandler, handlers map[string]JobHandler) *JobQueue {
+	c := *cfg
+	if c.Workers <= 0 {
+		c.Workers = defaultJobWorkers
+	}
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = defaultJobMaxAttempts
+	}
+	if c.RetryDelay <= 0 {
+		c.RetryDelay = defaultJobRetryDelay
+	}
+	if c.MaxRetryDelay <= 0 {
+		c.MaxRetryDelay = defaultJobMaxRetryDelay
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &JobQueue{
+		config:     c,
+		sqlHandler: sqlHandler,
+		handlers:   handlers,
+		wake:       make(chan struct{}, c.Workers),
+		stop:       make(chan struct{}),
+		ctx:        ctx,
+		cancel:     cancel,
+		changed:    make(chan struct{}),
+		waiters:    make(map[int64]*jobWaiters),
+	}
+}
+
+// 设置任务产生结果时的回调 需要在 Start 之前设置
+func (q *JobQueue) OnResult(fn func(JobResult)) {
+	q.onResult = fn
+}
+
+// 恢复上次未完成的任务并启动worker
+func (q *JobQueue) Start() error {
+	count, err := q.sqlHandler.ResetRunningJobs()
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		logrus.Infof("resume %d unfinished memory jobs", count)
+	}
+	for i := 0; i < q.config.Workers; i++ {
+		q.wg.Add(1)
+		go q.worker()
+	}
+	return nil
+}
+
+// 停止worker 取消执行中的任务并等待它们退出 被中断和未执行的任务保留在数据库中 下次启动时继续
+func (q *JobQueue) Stop() {
+	q.stopOnce.Do(func() {
+		q.stateMu.Lock()
+		q.stopped = true
+		q.stateMu.Unlock()
+		close(q.stop)
+		q.cancel()
+	})
+	q.wg.Wait()
+	q.notifyChanged()
+
+	// 未完成的任务不会再有结果 通知所有等待者和回调
+	q.stateMu.Lock()
+	waiters := q.waiters
+	q.waiters = make(map[int64]*jobWaiters)
+	q.stateMu.Unlock()
+	for _, w := range waiters {
+		q.deliver(w.chans, JobResult{Job: w.job, Err: ErrJobQueueStopped})
+	}
+}
+
+// 添加会话的后台任务
+func (q *JobQueue) Enqueue(jobType, userID, sessionID string) error {
+	_, err := q.enqueue(jobType, userID, sessionID, false)
+	return err
+}
+
+// 添加会话的后台任务 返回的通道在任务产生结果后收到一次结果
+// 同一会话同类型的等待中任务会被合并 多个等待者收到同一个结果
+func (q *JobQueue) EnqueueWait(jobType, userID, sessionID string) (<-chan JobResult, error) {
+	return q.enqueue(jobType, userID, sessionID, true)
+}
+
+func (q *JobQueue) enqueue(jobType, userID, sessionID string, wait bool) (chan JobResult, error) {
+	if _, ok := q.handlers[jobType]; !ok {
+		return nil, fmt.Errorf("unknown memory job type: %s", jobType)
+	}
+	// 持有领取锁 保证注册等待者之前任务不会被执行
+	q.claimMu.Lock()
+	job, err := q.sqlHandler.EnqueueJob(jobType, userID, sessionID)
+	if err != nil {
+		q.claimMu.Unlock()
+		return nil, err
+	}
+	var ch chan JobResult
+	stopped := false
+	if wait {
+		ch = make(chan JobResult, 1)
+		q.stateMu.Lock()
+		stopped = q.stopped
+		if !stopped {
+			w, ok := q.waiters[job.ID]
+			if !ok {
+				w = &jobWaiters{job: *job}
+				q.waiters[job.ID] = w
+			}
+			w.chans = append(w.chans, ch)
+		}
+		q.stateMu.Unlock()
+	}
+	q.claimMu.Unlock()
+	if stopped {
+		q.deliver([]chan JobResult{ch}, JobResult{Job: *job, Err: ErrJobQueueStopped})
+		return ch, nil
+	}
+
+	q.wakeWorker()
+	q.notifyChanged()
+	return ch, nil
+}
+
+// 列出任务 userID 和 status 为空时不过滤
+func (q *JobQueue) List(userID, status string) ([]model.MemoryJob, error) {
+	return q.sqlHandler.ListJobs(userID, status)
+}
+
+// 重新执行用户失败的任务
+func (q *JobQueue) Retry(userID string, id int64) (*model.MemoryJob, error) {
+	job, err := q.sqlHandler.RetryJob(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	q.wakeWorker()
+	q.notifyChanged()
+	return job, nil
+}
+
+// 等待会话中执行中和已到期的任务完成 userID 和 sessionID 为空时等待所有会话
+// 等待退避重试的任务不会阻塞 ctx 取消时返回ctx的错误
+func (q *JobQueue) WaitIdle(ctx context.Context, userID, sessionID string) error {
+	for {
+		q.stateMu.Lock()
+		changed := q.changed
+		stopped := q.stopped
+		q.stateMu.Unlock()
+
+		count, err := q.sqlHandler.CountActiveJobs(userID, sessionID, time.Now())
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return nil
+		}
+		if stopped {
+			return ErrJobQueueStopped
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (q *JobQueue) worker() {
+	defer q.wg.Done()
+	for {
+		select {
+		case <-q.stop:
+			return
+		default:
+		}
+
+		q.claimMu.Lock()
+		job, err := q.sqlHandler.ClaimJob(time.Now())
+		q.claimMu.Unlock()
+		if err != nil {
+			logrus.Errorf("claim memory job error: %v", err)
+			q.sleep(time.Second)
+			continue
+		}
+		if job == nil {
+			q.sleep(q.idleDuration())
+			continue
+		}
+		q.run(job)
+	}
+}
+
+// 执行任务并记录结果
+func (q *JobQueue) run(job *model.MemoryJob) {
+	events, err := q.execute(job)
+	defer q.notifyChanged()
+	// 任务结束后 同一会话等待中的任务可以执行了
+	defer q.wakeWorker()
+
+	// 停止队列中断的任务不计入尝试次数 恢复为等待中 下次启动时重新执行
+	if err != nil && q.ctx.Err() != nil {
+		logrus.Infof("memory job %d (%s %s/%s) interrupted by stop: %v", job.ID, job.Type, job.UserID, job.SessionID, err)
+		if err := q.sqlHandler.ReleaseJob(job.ID); err != nil {
+			logrus.Errorf("release memory job %d error: %v", job.ID, err)
+		}
+		return
+	}
+	if err == nil {
+		if err := q.sqlHandler.FinishJob(job.ID); err != nil {
+			logrus.Errorf("finish memory job %d error: %v", job.ID, err)
+		}
+		q.resolve(JobResult{Job: *job, Events: events})
+		return
+	}
+
+	var nextRunAt time.Time
+	if job.Attempts < q.config.MaxAttempts {
+		nextRunAt = time.Now().Add(q.backoff(job.Attempts))
+		logrus.Warnf("memory job %d (%s %s/%s) failed, attempt %d/%d, retry at %s: %v",
+			job.ID, job.Type, job.UserID, job.SessionID, job.Attempts, q.config.MaxAttempts, nextRunAt.Format(time.DateTime), err)
+	} else {
+		logrus.Errorf("memory job %d (%s %s/%s) failed after %d attempts: %v",
+			job.ID, job.Type, job.UserID, job.SessionID, job.Attempts, err)
+	}
+	if err := q.sqlHandler.FailJob(job.ID, err, nextRunAt); err != nil {
+		logrus.Errorf("save memory job %d error: %v", job.ID, err)
+	}
+	if nextRunAt.IsZero() {
+		job.Status = model.JobStatusFailed
+		job.LastError = err.Error()
+		q.resolve(JobResult{Job: *job, Events: events, Err: err})
+	}
+}
+
+// 将结果发送给任务的等待者和回调
+func (q *JobQueue) resolve(result JobResult) {
+	q.stateMu.Lock()
+	var chans []chan JobResult
+	if w, ok := q.waiters[result.Job.ID]; ok {
+		chans = w.chans
+		delete(q.waiters, result.Job.ID)
+	}
+	q.stateMu.Unlock()
+	q.deliver(chans, result)
+}
+
+// 每个结果发送给等待者并回调一次
+func (q *JobQueue) deliver(chans []chan JobResult, result JobResult) {
+	for _, ch := range chans {
+		ch <- result
+	}
+	if q.onResult != nil {
+		q.onResult(result)
+	}
+}
+
+func (q *JobQueue) execute(job *model.MemoryJob) (events []model.MemoryEvent, err error) {
+	handler, ok := q.handlers[job.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown memory job type: %s", job.Type)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			events, err = nil, fmt.Errorf("memory job panic: %v", r)
+		}
+	}()
+	return handler(q.ctx, job.UserID, job.SessionID)
+}
+
+// 第n次失败后的等待时间 RETRY_DELAY * 2^(n-1) 不超过 MAX_RETRY_DELAY
+func (q *JobQueue) backoff(attempts int) time.Duration {
+	delay := time.Duration(q.config.RetryDelay) * time.Second
+	maxDelay := time.Duration(q.config.MaxRetryDelay) * time.Second
+	for i := 1; i < attempts && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxDelay)
+}
+
+// 没有可执行的任务时 等待到最早的重试时间
+func (q *JobQueue) idleDuration() time.Duration {
+	next, err := q.sqlHandler.NextJobRunAt()
+	if err != nil || next.IsZero() {
+		return jobIdleInterval
+	}
+	wait := time.Until(next)
+	if wait <= 0 {
+		// 已到期但同一会话有任务在执行 等待执行中的任务结束后唤醒
+		return time.Second
+	}
+	return min(wait, jobIdleInterval)
+}
+
+func (q *JobQueue) sleep(d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-q.stop:
+	case <-q.wake:
+	case <-timer.C:
+	}
+}
+
+func (q *JobQueue) wakeWorker() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *JobQueue) notifyChanged() {
+	q.stateMu.Lock()
+	defer q.stateMu.Unlock()
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
@@ -0,0 +1,283 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xuanlv2002/miniMem0/config"
+	"github.com/xuanlv2002/miniMem0/db/sqldb"
+	"github.com/xuanlv2002/miniMem0/model"
+)
+
+// 使用临时数据库创建任务队列 handler 执行所有类型的任务 需要调用方 Start
+func newTestJobQueue(t *testing.T, cfg config.JobConfig, handler JobHandler) (*JobQueue, *sqldb.SqlHandler) {
+	t.Helper()
+	sqlHandler, err := sqldb.NewSQL(&config.SqlConfig{Path: filepath.Join(t.TempDir(), "jobs.db")})
+	if err != nil {
+		t.Fatalf("NewSQL: %v", err)
+	}
+	q := NewJobQueue(&cfg, sqlHandler, map[string]JobHandler{
+		model.JobTypeSummarize: handler,
+		model.JobTypeExtract:   handler,
+	})
+	t.Cleanup(q.Stop)
+	return q, sqlHandler
+}
+
+// 轮询直到条件成立
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// 查询任务 不存在时返回nil
+func getJob(t *testing.T, sqlHandler *sqldb.SqlHandler, id int64) *model.MemoryJob {
+	t.Helper()
+	jobs, err := sqlHandler.ListJobs("", "")
+	if err != nil {
+		t.Fatalf("ListJobs: %v", err)
+	}
+	for i := range jobs {
+		if jobs[i].ID == id {
+			return &jobs[i]
+		}
+	}
+	return nil
+}
+
+func TestJobBackoff(t *testing.T) {
+	q, _ := newTestJobQueue(t, config.JobConfig{RetryDelay: 5, MaxRetryDelay: 30}, nil)
+	want := []time.Duration{5 * time.Second, 10 * time.Second, 20 * time.Second, 30 * time.Second, 30 * time.Second}
+	for i, w := range want {
+		if got := q.backoff(i + 1); got != w {
+			t.Errorf("backoff(%d) = %s, want %s", i+1, got, w)
+		}
+	}
+}
+
+func TestJobQueueRetriesUntilFailed(t *testing.T) {
+	var calls atomic.Int32
+	var succeed atomic.Bool
+	q, sqlHandler := newTestJobQueue(t, config.JobConfig{Workers: 1, MaxAttempts: 3, RetryDelay: 5, MaxRetryDelay: 8},
+		func(ctx context.Context, userID, sessionID string) ([]model.MemoryEvent, error) {
+			calls.Add(1)
+			if succeed.Load() {
+				return []model.MemoryEvent{{ID: "m1", Event: "ADD"}}, nil
+			}
+			return nil, errors.New("model unavailable")
+		})
+	if err := q.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	done, err := q.EnqueueWait(model.JobTypeExtract, testUser, testSession)
+	if err != nil {
+		t.Fatalf("EnqueueWait: %v", err)
+	}
+	jobs, err := q.List(testUser, "")
+	if err != nil || len(jobs) != 1 {
+		t.Fatalf("List = %v, %v, want one job", jobs, err)
+	}
+	id := jobs[0].ID
+
+	// 前两次失败后按退避时间等待重试 等待者没有结果 WaitIdle 不等待退避中的任务
+	for attempt, delay := range []time.Duration{5 * time.Second, 8 * time.Second} {
+		attempt++
+		waitFor(t, "failed attempt", func() bool {
+			job := getJob(t, sqlHandler, id)
+			return job.Attempts == attempt && job.Status == model.JobStatusPending
+		})
+		job := getJob(t, sqlHandler, id)
+		if wait := time.Until(job.NextRunAt); wait < delay-time.Second || wait > delay {
+			t.Fatalf("attempt %d retries in %s, want %s", attempt, wait, delay)
+		}
+		if job.LastError != "model unavailable" {
+			t.Fatalf("got last error %q", job.LastError)
+		}
+		select {
+		case result := <-done:
+			t.Fatalf("waiter got %+v before the job finished", result)
+		default:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		err := q.WaitIdle(ctx, testUser, testSession)
+		cancel()
+		if err != nil {
+			t.Fatalf("WaitIdle with a backed-off job: %v", err)
+		}
+		// 提前到期 不等待真实的退避时间
+		if err := sqlHandler.DB.Model(&model.MemoryJob{}).Where("id = ?", id).Update("next_run_at", time.Now()).Error; err != nil {
+			t.Fatalf("update next_run_at: %v", err)
+		}
+		q.wakeWorker()
+	}
+
+	// 超过最大尝试次数后标记为失败 等待者收到错误
+	select {
+	case result := <-done:
+		if result.Err == nil || result.Job.Status != model.JobStatusFailed || result.Job.ID != id {
+			t.Fatalf("got result %+v, want the failed job", result)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the failed result")
+	}
+	job := getJob(t, sqlHandler, id)
+	if job.Status != model.JobStatusFailed || job.Attempts != 3 || calls.Load() != 3 {
+		t.Fatalf("got job %+v after %d calls, want failed after 3 attempts", job, calls.Load())
+	}
+
+	// 只有任务所属的用户可以重试 重试后尝试次数清零并立即执行
+	if _, err := q.Retry("other", id); err == nil {
+		t.Fatal("Retry by another user succeeded")
+	}
+	succeed.Store(true)
+	retried, err := q.Retry(testUser, id)
+	if err != nil {
+		t.Fatalf("Retry: %v", err)
+	}
+	if retried.Status != model.JobStatusPending || retried.Attempts != 0 {
+		t.Fatalf("got retried job %+v, want pending with no attempts", retried)
+	}
+	if _, err := q.Retry(testUser, id); err == nil {
+		t.Fatal("Retry of a pending job succeeded")
+	}
+	if err := q.WaitIdle(context.Background(), testUser, testSession); err != nil {
+		t.Fatalf("WaitIdle: %v", err)
+	}
+	if getJob(t, sqlHandler, id) != nil {
+		t.Fatal("finished job is not removed")
+	}
+}
+
+func TestEnqueueJobMergesAndResetsBackoff(t *testing.T) {
+	_, sqlHandler := newTestJobQueue(t, config.JobConfig{}, nil)
+	first, err := sqlHandler.EnqueueJob(model.JobTypeExtract, testUser, testSession)
+	if err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+	second, err := sqlHandler.EnqueueJob(model.JobTypeExtract, testUser, testSession)
+	if err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+	if second.ID != first.ID {
+		t.Fatalf("got job %d, want merged into %d", second.ID, first.ID)
+	}
+	other, err := sqlHandler.EnqueueJob(model.JobTypeSummarize, testUser, testSession)
+	if err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+	if other.ID == first.ID {
+		t.Fatal("different job types merged")
+	}
+
+	// 合并到退避中的任务时 立即执行并重新计算尝试次数
+	if _, err := sqlHandler.ClaimJob(time.Now()); err != nil {
+		t.Fatalf("ClaimJob: %v", err)
+	}
+	if err := sqlHandler.FailJob(first.ID, errors.New("boom"), time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("FailJob: %v", err)
+	}
+	before := time.Now()
+	merged, err := sqlHandler.EnqueueJob(model.JobTypeExtract, testUser, testSession)
+	if err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+	job := getJob(t, sqlHandler, first.ID)
+	if merged.ID != first.ID || job.Attempts != 0 || job.NextRunAt.After(time.Now()) || job.NextRunAt.Before(before) {
+		t.Fatalf("got job %+v, want backoff reset", job)
+	}
+}
+
+func TestClaimJobOnePerSession(t *testing.T) {
+	_, sqlHandler := newTestJobQueue(t, config.JobConfig{}, nil)
+	first, err := sqlHandler.EnqueueJob(model.JobTypeExtract, testUser, testSession)
+	if err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+	claimed, err := sqlHandler.ClaimJob(time.Now())
+	if err != nil || claimed == nil || claimed.ID != first.ID || claimed.Status != model.JobStatusRunning || claimed.Attempts != 1 {
+		t.Fatalf("ClaimJob = %+v, %v, want the first job running", claimed, err)
+	}
+
+	// 同一会话同类型的任务执行中时 新任务不会被领取 其他会话不受影响
+	second, err := sqlHandler.EnqueueJob(model.JobTypeExtract, testUser, testSession)
+	if err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+	if second.ID == first.ID {
+		t.Fatal("new job merged into the running job")
+	}
+	if claimed, err := sqlHandler.ClaimJob(time.Now()); err != nil || claimed != nil {
+		t.Fatalf("ClaimJob = %+v, %v, want nothing while the session is running", claimed, err)
+	}
+	other, err := sqlHandler.EnqueueJob(model.JobTypeExtract, testUser, "s2")
+	if err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+	if claimed, err := sqlHandler.ClaimJob(time.Now()); err != nil || claimed == nil || claimed.ID != other.ID {
+		t.Fatalf("ClaimJob = %+v, %v, want the other session", claimed, err)
+	}
+
+	// 程序重启后执行中的任务恢复为等待中
+	count, err := sqlHandler.ResetRunningJobs()
+	if err != nil || count != 2 {
+		t.Fatalf("ResetRunningJobs = %d, %v, want 2", count, err)
+	}
+	if job := getJob(t, sqlHandler, first.ID); job.Status != model.JobStatusPending {
+		t.Fatalf("got job %+v, want pending", job)
+	}
+}
+
+func TestJobQueueStopInterruptsRunningJob(t *testing.T) {
+	started := make(chan struct{})
+	q, sqlHandler := newTestJobQueue(t, config.JobConfig{Workers: 1},
+		func(ctx context.Context, userID, sessionID string) ([]model.MemoryEvent, error) {
+			close(started)
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})
+	if err := q.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	done, err := q.EnqueueWait(model.JobTypeExtract, testUser, testSession)
+	if err != nil {
+		t.Fatalf("EnqueueWait: %v", err)
+	}
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("job not started")
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		q.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop blocked by the running job")
+	}
+	if result := <-done; !errors.Is(result.Err, ErrJobQueueStopped) {
+		t.Fatalf("got result %+v, want ErrJobQueueStopped", result)
+	}
+
+	// 被中断的任务不计入尝试次数 下次启动时继续
+	jobs, err := sqlHandler.ListJobs(testUser, model.JobStatusPending)
+	if err != nil {
+		t.Fatalf("ListJobs: %v", err)
+	}
+	if len(jobs) != 1 || jobs[0].Attempts != 0 {
+		t.Fatalf("got jobs %+v, want the interrupted job pending", jobs)
+	}
+}
@@ -0,0 +1,50 @@
+package memory
+
+import "sync"
+
+/*
+	按键加锁 同一个键串行 不同的键互不阻塞
+	会话的抽取和总结进度按(用户,会话)加锁 用户的长期记忆写入按用户加锁
+	同时需要两把锁时 先锁会话再锁用户
+*/
+
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	mu   sync.Mutex
+	refs int // 持有和等待这把锁的数量 为0时释放
+}
+
+// 锁住键 返回解锁函数
+func (k *keyedMutex) Lock(key string) (unlock func()) {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = make(map[string]*keyedLock)
+	}
+	lock := k.locks[key]
+	if lock == nil {
+		lock = &keyedLock{}
+		k.locks[key] = lock
+	}
+	lock.refs++
+	k.mu.Unlock()
+
+	lock.mu.Lock()
+	return func() {
+		lock.mu.Unlock()
+		k.mu.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
+
+// 会话锁的键
+func sessionKey(userID, sessionID string) string {
+	return userID + "\x00" + sessionID
+}
@@ -0,0 +1,50 @@
+package memory
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeyedMutex(t *testing.T) {
+	var locks keyedMutex
+	unlockA := locks.Lock("a")
+
+	// 不同的键互不阻塞
+	done := make(chan struct{})
+	go func() {
+		locks.Lock("b")()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("lock b blocked by lock a")
+	}
+
+	// 同一个键等待解锁
+	acquired := make(chan struct{})
+	go func() {
+		unlock := locks.Lock("a")
+		close(acquired)
+		unlock()
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("lock a acquired twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlockA()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("lock a not released")
+	}
+
+	// 没有持有者的键被释放
+	locks.mu.Lock()
+	n := len(locks.locks)
+	locks.mu.Unlock()
+	if n != 0 {
+		t.Fatalf("got %d locks left, want 0", n)
+	}
+}
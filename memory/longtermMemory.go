@@ -4,6 +4,9 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"maps"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -18,6 +21,7 @@ import (
 	"github.com/xuanlv2002/miniMem0/llm"
 	"github.com/xuanlv2002/miniMem0/model"
 	"github.com/xuanlv2002/miniMem0/prompt"
+	"github.com/xuanlv2002/miniMem0/rerank"
 )
 
 /*
@@ -27,34 +31,61 @@ import (
 */
 
 type LongMemoryHandler struct {
-	config     *config.LongMemoryConfig
-	vector     *vector.Vector
-	llmHandler *llm.LLM
-	sqlHandler *sqldb.SqlHandler
-	mu         sync.Mutex     // 长期记忆锁
-	wg         sync.WaitGroup // 用来等待所有任务完成
+	config         *config.LongMemoryConfig
+	vector         *vector.Vector
+	llmHandler     llm.ChatModel
+	sqlHandler     *sqldb.SqlHandler
+	templates      *prompt.Templates
+	timeouts       *config.TimeoutConfig
+	embeddingModel string                    // 生成向量的模型 写入记忆元数据
+	reranker       rerank.Reranker           // 检索结果的重排序 为nil时不重排序
+	rerankConfig   *config.RerankConfig      // 重排序后保留的数量和最小得分
+	graphConfig    *config.GraphMemoryConfig // 关系记忆的开关和检索数量
+	sessionLocks   keyedMutex                // 会话的抽取进度锁 同一会话的抽取串行
+	userLocks      keyedMutex                // 用户的长期记忆写入锁
+	wg             sync.WaitGroup            // 用来等待所有任务完成
 }
 
 // 新建长期记忆系统
-func NewLongMemory(config *config.LongMemoryConfig, vector *vector.Vector, sqlHandler *sqldb.SqlHandler, llmModel *llm.LLM) *LongMemoryHandler {
+func NewLongMemory(config *config.LongMemoryConfig, vector *vector.Vector, sqlHandler *sqldb.SqlHandler, llmModel llm.ChatModel, templates *prompt.Templates, timeouts *config.TimeoutConfig, embeddingModel string, graphConfig *config.GraphMemoryConfig) *LongMemoryHandler {
 	return &LongMemoryHandler{
-		vector:     vector,
-		llmHandler: llmModel,
-		sqlHandler: sqlHandler,
-		config:     config,
+		vector:         vector,
+		llmHandler:     llmModel,
+		sqlHandler:     sqlHandler,
+		config:         config,
+		templates:      templates,
+		timeouts:       timeouts,
+		embeddingModel: embeddingModel,
+		graphConfig:    graphConfig,
 	}
 }
 
+// 设置检索结果的重排序器 为nil时不重排序
+func (l *LongMemoryHandler) SetReranker(reranker rerank.Reranker, cfg *config.RerankConfig) {
+	l.reranker = reranker
+	l.rerankConfig = cfg
+}
+
 // 等待所有任务完成
 func (l *LongMemoryHandler) WaitDone() {
 	l.wg.Wait()
 }
 
-// 获得相关长期记忆
-func (l *LongMemoryHandler) GetLongMemory(text string) (*model.LongMemory, error) {
+// 获得用户的相关长期记忆 opts 为过滤条件和返回数量 只会返回该用户的记忆
+func (l *LongMemoryHandler) GetLongMemory(ctx context.Context, userID, text string, opts vector.SearchOptions) (*model.LongMemory, error) {
+	return l.GetLongMemoryQueries(ctx, userID, []string{text}, opts)
+}
+
+// 使用多条检索语句获得用户的相关长期记忆 各语句的结果合并后按得分排序
+// 开启重排序时以所有检索语句作为查询
+func (l *LongMemoryHandler) GetLongMemoryQueries(ctx context.Context, userID string, queries []string, opts vector.SearchOptions) (*model.LongMemory, error) {
 	var LongMemory model.LongMemory
+	LongMemory.UserID = userID
+	LongMemory.Queries = queries
 	// 搜索
-	ret, err := l.vector.Search(context.Background(), text)
+	searchCtx, cancel := withTimeout(ctx, l.timeouts.Search)
+	defer cancel()
+	ret, err := l.vector.SearchMerged(searchCtx, queries, opts.WithWhere(userFilter(userID)))
 	if err != nil {
 		return nil, err
 	}
@@ -62,90 +93,185 @@ func (l *LongMemoryHandler) GetLongMemory(text string) (*model.LongMemory, error
 	var vectorMemory = make([]model.LongMemoryItem, 0)
 	for _, v := range ret {
 		vectorMemory = append(vectorMemory, model.LongMemoryItem{
+			ID:       v.ID,
 			Text:     v.Content,
 			Meta:     v.Metadata,
 			Similary: v.Similarity,
+			Score:    v.Score,
 		})
 	}
 
-	LongMemory.VectorMemorys = vectorMemory
+	LongMemory.VectorMemorys = l.rerank(ctx, strings.Join(queries, "\n"), vectorMemory)
+	l.touchMemories(userID, LongMemory.VectorMemorys)
+
+	// 展开检索语句中提到的实体
+	if l.graphConfig.Enable {
+		LongMemory.Relations, err = l.searchRelations(userID, queries)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	return &LongMemory, nil
 }
 
+// 对检索结果重排序 并按配置裁剪数量和最小得分
+// 重排序失败时记录警告 保留检索的顺序
+func (l *LongMemoryHandler) rerank(ctx context.Context, query string, items []model.LongMemoryItem) []model.LongMemoryItem {
+	if l.reranker == nil || len(items) == 0 {
+		return items
+	}
+	finalK := l.rerankConfig.FinalK
+	documents := make([]string, 0, len(items))
+	for _, item := range items {
+		documents = append(documents, item.Text)
+	}
+	rerankCtx, cancel := withTimeout(ctx, l.timeouts.LLM)
+	defer cancel()
+	results, err := l.reranker.Rerank(rerankCtx, query, documents)
+	if err != nil {
+		logrus.Warnf("rerank long memory failed, keep search order: %v", err)
+		if finalK > 0 && len(items) > finalK {
+			items = items[:finalK]
+		}
+		return items
+	}
+
+	reranked := make([]model.LongMemoryItem, 0, len(results))
+	for _, result := range results {
+		if result.Score < l.rerankConfig.MinScore {
+			continue
+		}
+		item := items[result.Index]
+		item.RerankScore = result.Score
+		reranked = append(reranked, item)
+		if finalK > 0 && len(reranked) >= finalK {
+			break
+		}
+	}
+	return reranked
+}
+
+// 列出用户的所有长期记忆
+func (l *LongMemoryHandler) ListLongMemory(ctx context.Context, userID string) ([]model.LongMemoryItem, error) {
+	ret, err := l.vector.List(ctx, userFilter(userID))
+	if err != nil {
+		return nil, err
+	}
+	var items = make([]model.LongMemoryItem, 0, len(ret))
+	for _, v := range ret {
+		items = append(items, model.LongMemoryItem{
+			ID:   v.ID,
+			Text: v.Content,
+			Meta: v.Metadata,
+		})
+	}
+	return items, nil
+}
+
+// 删除用户的长期记忆
+func (l *LongMemoryHandler) DeleteLongMemory(ctx context.Context, userID string, memoryIDs ...string) error {
+	unlock := l.userLocks.Lock(userID)
+	defer unlock()
+	for _, memoryID := range memoryIDs {
+		// 手动删除没有来源会话
+		if _, err := l.deleteMemory(ctx, memorySource{}, userID, memoryID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // 更新长期记忆 异步更新 不对系统进行阻塞
-func (l *LongMemoryHandler) UpdateLongMemory() {
+// 后台执行不跟随调用方的ctx 只受 TIMEOUT.EXTRACTION 限制
+func (l *LongMemoryHandler) UpdateLongMemory(userID, sessionID string) {
 	l.wg.Add(1)
 	go func() {
 		defer l.wg.Done()
-		err := l.SaveLongMemory()
+		_, err := l.SaveLongMemory(context.Background(), userID, sessionID)
 		if err != nil {
 			logrus.Errorf("LongMemory error: %v", err)
 		}
 	}()
 }
 
-// 更新长期记忆 异步更新 不对系统进行阻塞
-func (l *LongMemoryHandler) SaveLongMemory() error {
-	// 加锁
-	l.mu.Lock()
-	defer l.mu.Unlock()
+// 抽取会话中的长期记忆 事实按用户存储 返回实际执行的 ADD/UPDATE/DELETE 操作
+// 以及被拒绝的操作 被拒绝的操作 Reason 不为空
+func (l *LongMemoryHandler) SaveLongMemory(ctx context.Context, userID, sessionID string) ([]model.MemoryEvent, error) {
+	// 按会话加锁 不同会话和用户的抽取可以并行 写入记忆时再按用户加锁
+	unlock := l.sessionLocks.Lock(sessionKey(userID, sessionID))
+	defer unlock()
+	ctx, cancel := withTimeout(ctx, l.timeouts.Extraction)
+	defer cancel()
 	// 获得长期记忆位置 获得长期记忆已经存储到的位置
-	longMemory, err := l.sqlHandler.GetLastLongMemroy()
+	longMemory, err := l.sqlHandler.GetLastLongMemroy(userID, sessionID)
 	if err != nil {
 		logrus.Errorf("failed to get last long memory: %v", err)
-		return err
+		return nil, err
+	}
+	// 上次抽取写入到一半失败时 按保存的写入计划继续 不重新请求模型
+	run, err := l.sqlHandler.GetUnfinishedExtractionRun(userID, sessionID, longMemory.LastExtractionID)
+	if err != nil {
+		return nil, err
+	}
+	if run != nil {
+		var plan extractionPlan
+		if err := json.Unmarshal([]byte(run.Plan), &plan); err != nil {
+			return nil, fmt.Errorf("failed to decode plan of extraction run %d: %v", run.ID, err)
+		}
+		logrus.Infof("Resume extraction run %d", run.ID)
+		return l.applyRun(ctx, longMemory, run, &plan)
 	}
 	// 判断是否需要更新记忆
-	count, err := l.sqlHandler.GetUnExtractionMemoryCount(longMemory.LastExtractionID)
+	count, err := l.sqlHandler.GetUnExtractionMemoryCount(userID, sessionID, longMemory.LastExtractionID)
 	if err != nil {
 		logrus.Errorf("failed to get unextraction memory count: %v", err)
-		return err
+		return nil, err
 	}
 
 	// 如果小于则不更新记忆
 	if count < int64(l.config.LongGap) {
 		logrus.Infof("No new memories to extract, current count: %d, required gap: %d", count, l.config.LongGap)
-		return nil
+		return nil, nil
 	}
 
 	// 获得上下文记忆
-	contextMemory, err := l.sqlHandler.GetLastContextMemory()
+	contextMemory, err := l.sqlHandler.GetLastContextMemory(userID, sessionID)
 	if err != nil {
 		logrus.Errorf("failed to get last context memory: %v", err)
-		return err
+		return nil, err
 	}
 
 	// 获得未抽取的记忆
-	originalMemories, findCount, err := l.sqlHandler.GetLastOriginalMemory(int(count))
+	originalMemories, findCount, err := l.sqlHandler.GetLastOriginalMemory(userID, sessionID, int(count))
 	if err != nil {
 		logrus.Errorf("failed to get last original memory: %v", err)
-		return err
+		return nil, err
 	}
 
 	if findCount <= 0 {
 		// 如果没有未总结的记忆则不进行总结
 		logrus.Info("No new memories to extract, find count is 0")
-		return nil
+		return nil, nil
 	}
 
 	// 组装信息
-	var content string
-	content += contextMemory.GetPrompt()
-	content += "\n !！注解 !! 记忆上下文是基于大模型对整体对话的一个总结，可能与当前对话不完全相关，但可以作为参考。\n\n"
-
-	content += "#待提取信息记忆: \n"
+	data := prompt.ExtractionInputData{}
+	contents := make([]string, 0, len(originalMemories))
 	for _, v := range originalMemories {
-		content += string(v.Role) + ":" + v.Content
-		content += "\n记忆元数据"
-		content += "记忆时间:" + v.CreatedAt.Format("2006-01-02 15:04:05") + "\n\n"
+		data.Turns = append(data.Turns, v.Turn())
+		contents = append(contents, v.Content)
 	}
+	// 按对话内容选择模板语言
+	templates := l.templates.Detect(contents...)
+	data.Context = contextMemory.GetPromptWith(templates)
+	content := templates.Render(prompt.ExtractionInputTemplate, data)
 
 	// 抽取长期记忆
-	facts, err := l.ExtractFacts(context.Background(), content)
+	facts, err := l.ExtractFacts(ctx, templates, content)
 	if err != nil {
 		logrus.Errorf("failed to extract facts: %v", err)
-		return err
+		return nil, err
 	}
 	if len(facts) == 0 {
 		logrus.Info("no new facts found")
@@ -153,18 +279,25 @@ func (l *LongMemoryHandler) SaveLongMemory() error {
 		longMemory.UpdatedAt = time.Now()
 		err = l.sqlHandler.SaveLongMemoryLastExtractionID(longMemory)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		return nil
+		return nil, nil
 	}
 
 	// 抽取相关长期记忆
 	var retrievedOldMemoriesMap = make(map[string]model.LongMemoryItem)
+	var searches = make([]string, 0, len(facts))
 	for _, fact := range facts {
-		memories, err := l.vector.Search(context.Background(), fact.Content)
-		if err != nil {
-			return fmt.Errorf("failed to search memories: %v", err)
-		}
+		searches = append(searches, fact.Content)
+	}
+	// 批量查询 所有事实只需一次向量化请求
+	searchCtx, cancelSearch := withTimeout(ctx, l.timeouts.Search)
+	results, err := l.vector.SearchBatch(searchCtx, searches, vector.SearchOptions{Where: userFilter(userID)})
+	cancelSearch()
+	if err != nil {
+		return nil, fmt.Errorf("failed to search memories: %v", err)
+	}
+	for _, memories := range results {
 		// 抽取到的相关记忆
 		for _, mem := range memories {
 			retrievedOldMemoriesMap[mem.ID] = model.LongMemoryItem{
@@ -181,170 +314,406 @@ func (l *LongMemoryHandler) SaveLongMemory() error {
 	}
 	// 解决记忆冲突
 	// 对记忆进行修改处理
-	safeMemories, err := l.processMemory(context.Background(), facts, retrievedOldMemories)
+	safeMemories, rejected, err := l.processMemory(ctx, templates, facts, retrievedOldMemories)
+	if err != nil {
+		return nil, fmt.Errorf("failed to process memories: %v", err)
+	}
+	logrus.Debugf("safeMemories: %v", safeMemories)
+	plan := &extractionPlan{Events: safeMemories, Rejected: rejected}
+	for _, v := range originalMemories {
+		plan.MessageIDs = append(plan.MessageIDs, v.ID)
+	}
+	// 抽取实体和关系 在写入之前完成
+	if l.graphConfig.Enable {
+		plan.Graph, err = l.extractGraph(ctx, templates, userID, facts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract graph: %v", err)
+		}
+	}
+	planJSON, err := json.Marshal(plan)
 	if err != nil {
-		return fmt.Errorf("failed to process memories: %v", err)
+		return nil, err
+	}
+	// 记录本次抽取和写入计划 用于整批撤销和写入失败后继续
+	run = &model.ExtractionRun{
+		UserID:           userID,
+		SessionID:        sessionID,
+		PrevExtractionID: longMemory.LastExtractionID,
+		SourceStartID:    originalMemories[0].ID,
+		SourceEndID:      originalMemories[len(originalMemories)-1].ID,
+		Plan:             string(planJSON),
 	}
-	fmt.Println("safeMemories:", safeMemories)
-	// 更新长期记忆
-	for _, mem := range safeMemories {
+	if err := l.sqlHandler.AddExtractionRun(run); err != nil {
+		return nil, fmt.Errorf("failed to save extraction run: %v", err)
+	}
+	return l.applyRun(ctx, longMemory, run, plan)
+}
+
+// 一次抽取的写入计划 模型返回的结果在写入之前保存 写入失败时重试不再请求模型
+type extractionPlan struct {
+	Events     []model.MemoryEvent `json:"events"`             // 待执行的记忆操作 ADD 的ID已经生成
+	Rejected   []model.MemoryEvent `json:"rejected,omitempty"` // 被拒绝的操作
+	MessageIDs []int64             `json:"message_ids"`        // 来源对话 OriginalMemory 的ID
+	Graph      *graphUpdate        `json:"graph,omitempty"`    // 关系变更 未开启关系记忆时为空
+}
+
+// 按写入计划执行一次抽取 已有本次抽取变更记录的操作不再重新记录 按记录补齐向量库后跳过
+// 关系变更在事务中写入 全部完成后在同一事务中标记抽取完成并更新会话的抽取进度
+func (l *LongMemoryHandler) applyRun(ctx context.Context, longMemory *model.LongMemory, run *model.ExtractionRun, plan *extractionPlan) ([]model.MemoryEvent, error) {
+	userID := run.UserID
+	source := memorySource{
+		SessionID:  run.SessionID,
+		StartID:    run.SourceStartID,
+		EndID:      run.SourceEndID,
+		MessageIDs: plan.MessageIDs,
+		RunID:      run.ID,
+	}
+	unlock := l.userLocks.Lock(userID)
+	defer unlock()
+	// 被拒绝的操作也一并返回
+	applied := slices.Clone(plan.Rejected)
+	for _, mem := range plan.Events {
 		event := mem.Event
 		memoryID := mem.ID
 		text := mem.Text
 		meta := mem.Meta
 
+		if event == "NONE" {
+			logrus.Infof("Keeping memory unchanged: %s", text)
+			continue
+		}
+		done, err := l.sqlHandler.GetRunMemoryHistory(run.ID, memoryID)
+		if err != nil {
+			return applied, err
+		}
+		if done != nil {
+			if err := l.completeHistory(ctx, done); err != nil {
+				return applied, fmt.Errorf("failed to complete memory %s: %v", memoryID, err)
+			}
+			mem.Meta = done.NewMeta
+			mem.OldMemory = done.OldText
+			applied = append(applied, mem)
+			continue
+		}
+
+		// 不同会话的抽取并行执行 生成写入计划后记忆可能已被其他会话或手动删除
+		if event != "ADD" && l.vector.Get(ctx, memoryID) == nil {
+			mem.Reason = fmt.Sprintf("memory %s no longer exists", memoryID)
+			logrus.Warnf("rejected memory event %s %s: %s", event, text, mem.Reason)
+			applied = append(applied, mem)
+			continue
+		}
+
 		switch event {
 		case "ADD":
-			if _, err := l.addMemory(context.Background(), text, meta); err != nil {
-				return fmt.Errorf("failed to add memory: %v", err)
+			doc, err := l.addMemory(ctx, source, userID, memoryID, text, meta, mem.Importance)
+			if err != nil {
+				return applied, fmt.Errorf("failed to add memory: %v", err)
 			}
+			mem.Meta = doc.Metadata
+			mem.OldMemory = ""
+			applied = append(applied, mem)
 			logrus.Infof("Added memory: %s", text)
 		case "UPDATE":
-			if err := l.updateMemory(context.Background(), memoryID, text, meta); err != nil {
-				return fmt.Errorf("failed to update memory: %v", err)
+			old, doc, err := l.updateMemory(ctx, source, userID, memoryID, text, meta, mem.Importance)
+			if err != nil {
+				return applied, fmt.Errorf("failed to update memory: %v", err)
 			}
+			mem.Meta = doc.Metadata
+			mem.OldMemory = old.Content
+			applied = append(applied, mem)
 			logrus.Infof("Updated memory: %s", text)
 		case "DELETE":
-			if err := l.deleteMemory(context.Background(), memoryID); err != nil {
-				return fmt.Errorf("failed to delete memory: %v", err)
+			old, err := l.deleteMemory(ctx, source, userID, memoryID)
+			if err != nil {
+				return applied, fmt.Errorf("failed to delete memory: %v", err)
 			}
+			mem.OldMemory = old.Content
+			applied = append(applied, mem)
 			logrus.Infof("Deleted memory: %s", memoryID)
-		case "NONE":
-			logrus.Infof("Keeping memory unchanged: %s", text)
 		}
 	}
 
+	// 更新关系记忆 与写入标记在同一事务中 重试时不会重复写入
+	if plan.Graph != nil && !run.GraphApplied {
+		err := l.sqlHandler.Transaction(func(tx *sqldb.SqlHandler) error {
+			if err := l.applyGraph(tx, userID, run.ID, plan.Graph); err != nil {
+				return err
+			}
+			return tx.MarkExtractionRunGraphApplied(run)
+		})
+		if err != nil {
+			run.GraphApplied = false
+			return applied, fmt.Errorf("failed to save graph: %v", err)
+		}
+	}
+
+	// 超出容量时淘汰价值最低的记忆 按当前的记忆重新计算 重试时可以重复执行
+	if err := l.enforceCapacity(ctx, source, userID); err != nil {
+		return applied, err
+	}
+
 	// 更新长期记忆位置
-	longMemory.LastExtractionID = originalMemories[len(originalMemories)-1].ID
+	longMemory.LastExtractionID = run.SourceEndID
 	longMemory.UpdatedAt = time.Now()
-	err = l.sqlHandler.SaveLongMemoryLastExtractionID(longMemory)
-	if err != nil {
-		return err
+	if err := l.sqlHandler.FinishExtractionRun(run, longMemory, time.Now()); err != nil {
+		return applied, err
 	}
-	return nil
+	return applied, nil
 }
 
 // 事实提取 提取长期记忆内容
-func (l *LongMemoryHandler) ExtractFacts(ctx context.Context, conversation string) ([]model.Fact, error) {
+func (l *LongMemoryHandler) ExtractFacts(ctx context.Context, templates *prompt.Templates, conversation string) ([]model.Fact, error) {
 	// 调用LLM进行事实提取
-	result, err := l.llmHandler.Chat(ctx, []openai.ChatCompletionMessage{
+	var response struct {
+		Facts []model.Fact `json:"facts"`
+	}
+	err := l.chatJSON(ctx, templates, []openai.ChatCompletionMessage{
 		{
 			Role:    openai.ChatMessageRoleSystem,
-			Content: prompt.FACT_EXTRACTION_PROMPT,
+			Content: templates.System(prompt.FactExtractionTemplate),
 		}, {
 			Role:    openai.ChatMessageRoleUser,
 			Content: conversation,
 		},
-	})
+	}, factsSchema, &response)
 	if err != nil {
 		return nil, err
 	}
 
-	var response struct {
-		Facts []model.Fact `json:"facts"`
-	}
-
-	jsContent := parseJson(result.Content)
-
-	if err := json.Unmarshal([]byte(jsContent), &response); err != nil {
-		return nil, fmt.Errorf("failed to parse LLM response: %v", err)
-	}
-
 	return response.Facts, nil
 }
-func (l *LongMemoryHandler) processMemory(ctx context.Context, newFacts []model.Fact, oldMemory []model.LongMemoryItem) ([]model.MemoryEvent, error) {
-	content := "#新获取的事实: \n"
+
+// 合并新事实与已有记忆 得到记忆操作
+// 提示词中已有记忆使用临时编号代替真实ID 模型返回后再转换回真实ID
+// UPDATE/DELETE/NONE 引用了不存在的编号 或重复修改同一条记忆时拒绝执行
+func (l *LongMemoryHandler) processMemory(ctx context.Context, templates *prompt.Templates, newFacts []model.Fact, oldMemory []model.LongMemoryItem) ([]model.MemoryEvent, []model.MemoryEvent, error) {
+	var data prompt.ProcessingInputData
 	for _, fact := range newFacts {
-		content += fmt.Sprintf("   -内容: %s, 出现时间: %s, 关于: %s\n", fact.Content, fact.AppearTime, fact.About)
+		data.Facts = append(data.Facts, prompt.Fact{
+			Content:    fact.Content,
+			AppearTime: fact.AppearTime,
+			About:      fact.About,
+		})
 	}
-
-	content += "\n#可能相关的记忆: \n"
-	for _, v := range oldMemory {
-		content += fmt.Sprintf("   -ID: %s, 内容: %s, 元数据: %v\n", v.ID, v.Text, v.Meta)
+	// 临时编号 "1" "2" ... 与提示词中的示例一致 ADD 的编号从最大编号往后递增
+	aliases := make(map[string]string, len(oldMemory))
+	for i, v := range oldMemory {
+		alias := strconv.Itoa(i + 1)
+		aliases[alias] = v.ID
+		data.Memories = append(data.Memories, prompt.LongItem{
+			ID:   alias,
+			Text: v.Text,
+			Meta: model.PromptMeta(v.Meta),
+		})
 	}
+	content := templates.Render(prompt.ProcessingInputTemplate, data)
 
-	result, err := l.llmHandler.Chat(ctx, []openai.ChatCompletionMessage{
+	// 模型只输出操作 重要性由程序根据对应的事实写入
+	var response struct {
+		Memory []struct {
+			ID    string            `json:"id"`
+			Text  string            `json:"text"`
+			Meta  map[string]string `json:"meta"`
+			Event string            `json:"event"`
+		} `json:"memory"`
+	}
+	err := l.chatJSON(ctx, templates, []openai.ChatCompletionMessage{
 		{
 			Role:    openai.ChatMessageRoleSystem,
-			Content: prompt.MEMORY_PROCESSING_PROMPT,
+			Content: templates.System(prompt.MemoryProcessingTemplate),
 		},
 		{
 			Role:    openai.ChatMessageRoleUser,
 			Content: content,
 		},
-	})
+	}, memorySchema, &response)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	var response struct {
-		Memory []model.MemoryEvent `json:"memory"`
-	}
-
-	fmt.Println("大模型记忆更新输出:", result.Content)
-	jsContent := parseJson(result.Content)
-	if err := json.Unmarshal([]byte(jsContent), &response); err != nil {
-		return nil, fmt.Errorf("failed to parse LLM response: %v", err)
+	var events, rejected []model.MemoryEvent
+	changed := make(map[string]bool)
+	for _, out := range response.Memory {
+		mem := model.MemoryEvent{ID: out.ID, Text: out.Text, Meta: out.Meta, Event: out.Event}
+		switch mem.Event {
+		case "ADD":
+			// 新记忆的ID在这里生成 保存在写入计划中 重试时可以判断是否已经添加
+			mem.ID = uuid.New().String()
+			mem.Importance = factImportance(newFacts, mem.Text)
+		case "UPDATE", "DELETE", "NONE":
+			memoryID, ok := aliases[mem.ID]
+			if !ok {
+				mem.Reason = fmt.Sprintf("memory id %q is not in the retrieved memories", mem.ID)
+				break
+			}
+			mem.ID = memoryID
+			if mem.Event == "NONE" {
+				break
+			}
+			if changed[memoryID] {
+				mem.Reason = fmt.Sprintf("memory id %q is changed more than once", mem.ID)
+				break
+			}
+			changed[memoryID] = true
+			if mem.Event == "UPDATE" {
+				mem.Importance = factImportance(newFacts, mem.Text)
+			}
+		default:
+			mem.Reason = fmt.Sprintf("unknown event %q", mem.Event)
+		}
+		if mem.Reason != "" {
+			logrus.Warnf("rejected memory event %s %s: %s", mem.Event, mem.Text, mem.Reason)
+			rejected = append(rejected, mem)
+			continue
+		}
+		events = append(events, mem)
 	}
-
-	return response.Memory, nil
+	return events, rejected, nil
 }
 
-// 添加记忆
-func (l *LongMemoryHandler) addMemory(ctx context.Context, text string, metadata map[string]string) (string, error) {
+// 添加记忆 返回写入的记忆
+func (l *LongMemoryHandler) addMemory(ctx context.Context, source memorySource, userID, memoryID, text string, metadata map[string]string, importance int) (*chromem.Document, error) {
 	// 持久记忆的ID
-	memoryID := uuid.New().String()
+	doc := chromem.Document{
+		ID:       memoryID,
+		Metadata: l.stampMeta(userID, nil, metadata, source, 1, importance),
+		Content:  text,
+	}
 
 	// 将文本转换为向量 并存入数据库
-	err := l.vector.Add(ctx, []chromem.Document{
-		{
-			ID:       memoryID,
-			Metadata: metadata,
-			Content:  text,
-		},
-	}, 1)
+	err := l.recordHistory(source, userID, doc.ID, "ADD", 1, nil, text, doc.Metadata, func() error {
+		return l.vector.Add(ctx, []chromem.Document{doc}, 1)
+	})
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return memoryID, nil
+	return &doc, nil
 }
 
-// 更新记忆
-func (l *LongMemoryHandler) updateMemory(ctx context.Context, memoryID, newText string, metadata map[string]string) error {
+// 更新记忆 只能更新属于该用户的记忆 元数据在原有的基础上合并
+// 返回更新前和更新后的记忆
+func (l *LongMemoryHandler) updateMemory(ctx context.Context, source memorySource, userID, memoryID, newText string, metadata map[string]string, importance int) (*chromem.Document, *chromem.Document, error) {
+	old, err := l.checkOwner(ctx, userID, memoryID)
+	if err != nil {
+		return nil, nil, err
+	}
+	version, err := l.nextVersion(userID, memoryID)
+	if err != nil {
+		return nil, nil, err
+	}
+	doc := chromem.Document{
+		ID:       memoryID,
+		Metadata: l.stampMeta(userID, old.Metadata, metadata, source, version, importance),
+		Content:  newText,
+	}
 	// 将文本转换为向量 并存入数据库
-	err := l.vector.Add(ctx, []chromem.Document{
-		{
-			ID:       memoryID,
-			Metadata: metadata,
-			Content:  newText,
-		},
-	}, 1)
+	err = l.recordHistory(source, userID, memoryID, "UPDATE", version, old, newText, doc.Metadata, func() error {
+		return l.vector.Add(ctx, []chromem.Document{doc}, 1)
+	})
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	return nil
+	return old, &doc, nil
 
 }
 
-// 删除记忆
-func (l *LongMemoryHandler) deleteMemory(ctx context.Context, memoryID string) error {
-	err := l.vector.Delete(ctx, []string{memoryID})
+// 删除记忆 只能删除属于该用户的记忆 返回删除前的记忆
+func (l *LongMemoryHandler) deleteMemory(ctx context.Context, source memorySource, userID, memoryID string) (*chromem.Document, error) {
+	old, err := l.checkOwner(ctx, userID, memoryID)
 	if err != nil {
-		return fmt.Errorf("failed to delete memory: %v", err)
+		return nil, err
+	}
+	version, err := l.nextVersion(userID, memoryID)
+	if err != nil {
+		return nil, err
+	}
+	err = l.recordHistory(source, userID, memoryID, "DELETE", version, old, "", nil, func() error {
+		return l.vector.Delete(ctx, []string{memoryID})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete memory: %v", err)
 	}
+	l.deleteStats(userID, memoryID)
 
-	return nil
+	return old, nil
+}
+
+// 删除记忆的使用情况 记忆已经删除 失败时只记录警告
+func (l *LongMemoryHandler) deleteStats(userID, memoryID string) {
+	if err := l.sqlHandler.DeleteMemoryStats(userID, []string{memoryID}); err != nil {
+		logrus.Warnf("failed to delete stats of memory %s: %v", memoryID, err)
+	}
+}
+
+// 写入系统维护的元数据 old 为记忆原有的元数据 新元数据在其基础上合并
+// 大模型返回的元数据不能覆盖系统字段 来源对话的ID与原有的合并
+// importance 大于0时写入重要性 已有重要性时取较高的值
+func (l *LongMemoryHandler) stampMeta(userID string, old, metadata map[string]string, source memorySource, version, importance int) map[string]string {
+	ret := make(map[string]string, len(old)+len(metadata)+7)
+	maps.Copy(ret, old)
+	for k, v := range metadata {
+		if !model.IsSystemMeta(k) {
+			ret[k] = v
+		}
+	}
+	now := time.Now().Format(time.RFC3339)
+	if ret[model.MetaCreatedAt] == "" {
+		ret[model.MetaCreatedAt] = now
+	}
+	ret[model.MetaUpdatedAt] = now
+	ret[model.MetaUserID] = userID
+	ret[model.MetaVersion] = strconv.Itoa(version)
+	ret[model.MetaEmbeddingModel] = l.embeddingModel
+	if source.RunID != 0 {
+		ret[model.MetaExtractionRun] = strconv.FormatInt(source.RunID, 10)
+	}
+	if importance > 0 {
+		if prev, err := strconv.Atoi(ret[model.MetaImportance]); err == nil {
+			importance = max(importance, prev)
+		}
+		ret[model.MetaImportance] = strconv.Itoa(min(importance, 10))
+	}
+	if len(source.MessageIDs) > 0 {
+		ret[model.MetaSourceMessageIDs] = mergeIDs(ret[model.MetaSourceMessageIDs], source.MessageIDs)
+	}
+	return ret
 }
 
-func parseJson(s string) string {
-	if strings.HasPrefix(s, "```json") && strings.HasSuffix(s, "```") {
-		// 提取 JSON 部分
-		s = strings.TrimPrefix(s, "```json")
-		s = strings.TrimSuffix(s, "```")
+// 合并逗号分隔的ID 去重并按从小到大排序
+func mergeIDs(ids string, more []int64) string {
+	set := make(map[int64]bool, len(more))
+	for _, v := range strings.Split(ids, ",") {
+		if id, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil {
+			set[id] = true
+		}
+	}
+	for _, id := range more {
+		set[id] = true
+	}
+	sorted := slices.Sorted(maps.Keys(set))
+	parts := make([]string, 0, len(sorted))
+	for _, id := range sorted {
+		parts = append(parts, strconv.FormatInt(id, 10))
+	}
+	return strings.Join(parts, ",")
+}
+
+// 校验记忆是否属于该用户 返回当前的记忆
+func (l *LongMemoryHandler) checkOwner(ctx context.Context, userID, memoryID string) (*chromem.Document, error) {
+	doc, err := l.vector.Collection.GetByID(ctx, memoryID)
+	if err != nil {
+		return nil, err
 	}
+	if doc.Metadata[model.MetaUserID] != userID {
+		return nil, fmt.Errorf("memory %s does not belong to user %s", memoryID, userID)
+	}
+	return &doc, nil
+}
 
-	// 直接返回原始字符串（可能是纯 JSON）
-	return strings.TrimSpace(s)
+// 按用户过滤长期记忆的条件
+func userFilter(userID string) map[string]string {
+	return map[string]string{model.MetaUserID: userID}
 }
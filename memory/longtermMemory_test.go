@@ -0,0 +1,262 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/xuanlv2002/miniMem0/llm/llmtest"
+	"github.com/xuanlv2002/miniMem0/model"
+)
+
+func TestSaveLongMemoryResumesAfterWriteFailure(t *testing.T) {
+	chat := llmtest.NewScriptedChatModel()
+	m := newTestMemorySystem(t, chat)
+	addTurn(t, m, "我叫小明 住在上海", "你好小明")
+
+	chat.Push(
+		llmtest.Response{Content: factsResponse(t,
+			model.Fact{Content: "我叫小明", About: "user"},
+			model.Fact{Content: "住在上海", About: "user"},
+		)},
+		llmtest.Response{Content: memoryResponse(t,
+			model.MemoryEvent{ID: "1", Text: "我叫小明", Event: "ADD"},
+			model.MemoryEvent{ID: "2", Text: "住在上海", Event: "ADD"},
+		)},
+	)
+
+	// 第二条记忆向量化失败
+	embed := m.vectorHandler.BatchEmbeddingFunc
+	calls := 0
+	m.vectorHandler.BatchEmbeddingFunc = func(ctx context.Context, texts []string) ([][]float32, error) {
+		calls++
+		if calls == 3 {
+			return nil, errors.New("embedding unavailable")
+		}
+		return embed(ctx, texts)
+	}
+	if _, err := m.LongMemoryHandler.SaveLongMemory(context.Background(), testUser, testSession); err == nil {
+		t.Fatal("SaveLongMemory succeeded, want embedding error")
+	}
+
+	// 重试按写入计划继续 不再请求模型 已写入的记忆不会重复添加
+	events, err := m.LongMemoryHandler.SaveLongMemory(context.Background(), testUser, testSession)
+	if err != nil {
+		t.Fatalf("SaveLongMemory retry: %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want 2", len(events))
+	}
+	if chat.Remaining() != 0 || len(chat.Calls()) != 2 {
+		t.Fatalf("got %d model calls, want 2", len(chat.Calls()))
+	}
+	items, err := m.ListLongMemory(testUser)
+	if err != nil {
+		t.Fatalf("ListLongMemory: %v", err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("got %d memories, want 2: %+v", len(items), items)
+	}
+	runs, err := m.ListExtractionRuns(testUser, testSession)
+	if err != nil {
+		t.Fatalf("ListExtractionRuns: %v", err)
+	}
+	if len(runs) != 1 || runs[0].FinishedAt == nil {
+		t.Fatalf("got runs %+v, want one finished run", runs)
+	}
+	for _, item := range items {
+		history, err := m.History(testUser, item.ID)
+		if err != nil {
+			t.Fatalf("History: %v", err)
+		}
+		if len(history) != 1 || history[0].Event != "ADD" {
+			t.Fatalf("memory %q history %+v, want one ADD", item.Text, history)
+		}
+	}
+
+	// 抽取进度已经更新 没有新对话时不再抽取
+	events, err = m.LongMemoryHandler.SaveLongMemory(context.Background(), testUser, testSession)
+	if err != nil || events != nil {
+		t.Fatalf("SaveLongMemory after finish = %v, %v, want nothing to extract", events, err)
+	}
+}
+
+func TestSaveLongMemory(t *testing.T) {
+	chat := llmtest.NewScriptedChatModel()
+	m := newTestMemorySystem(t, chat)
+	ctx := context.Background()
+
+	addTurn(t, m, "我叫小明 住在上海 喜欢游泳", "你好小明")
+	chat.Push(
+		llmtest.Response{Content: factsResponse(t,
+			model.Fact{Content: "我叫小明", About: "user"},
+			model.Fact{Content: "住在上海", About: "user"},
+			model.Fact{Content: "喜欢游泳", About: "user"},
+		)},
+		llmtest.Response{Content: memoryResponse(t,
+			model.MemoryEvent{Text: "我叫小明", Event: "ADD", Meta: map[string]string{"about": "user"}},
+			model.MemoryEvent{Text: "住在上海", Event: "ADD"},
+			model.MemoryEvent{Text: "喜欢游泳", Event: "ADD"},
+		)},
+	)
+	events, err := m.LongMemoryHandler.SaveLongMemory(ctx, testUser, testSession)
+	if err != nil {
+		t.Fatalf("SaveLongMemory: %v", err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("got %d events, want 3", len(events))
+	}
+	name := findMemory(t, m, "我叫小明")
+	if name == nil {
+		t.Fatal("memory not saved")
+	}
+	if name.Meta["about"] != "user" || name.Meta[model.MetaUserID] != testUser || name.Meta[model.MetaVersion] != "1" {
+		t.Fatalf("got meta %v, want the model meta and system fields", name.Meta)
+	}
+
+	// 已有记忆以临时编号交给模型 更新和删除按编号找到真实的记忆
+	addTurn(t, m, "我搬到北京了 不再游泳了", "好的")
+	chat.Handler = func(messages []openai.ChatCompletionMessage) llmtest.Response {
+		input := messages[len(messages)-1].Content
+		if !strings.Contains(input, "#可能相关的记忆") {
+			return llmtest.Response{Content: factsResponse(t,
+				model.Fact{Content: "搬到北京", About: "user"},
+				model.Fact{Content: "不再游泳", About: "user"},
+			)}
+		}
+		var city, swim string
+		for _, line := range strings.Split(input, "\n") {
+			switch {
+			case strings.Contains(line, "住在上海"):
+				city = memoryAlias(line)
+			case strings.Contains(line, "喜欢游泳"):
+				swim = memoryAlias(line)
+			}
+		}
+		return llmtest.Response{Content: memoryResponse(t,
+			model.MemoryEvent{ID: city, Text: "住在北京", Event: "UPDATE"},
+			model.MemoryEvent{ID: swim, Text: "喜欢游泳", Event: "DELETE"},
+			model.MemoryEvent{ID: "99", Text: "不存在", Event: "UPDATE"},
+		)}
+	}
+	events, err = m.LongMemoryHandler.SaveLongMemory(ctx, testUser, testSession)
+	if err != nil {
+		t.Fatalf("SaveLongMemory: %v", err)
+	}
+	// 引用不存在编号的操作被拒绝 随结果一起返回
+	if len(events) != 3 || events[0].Reason == "" || events[1].Reason != "" || events[2].Reason != "" {
+		t.Fatalf("got events %+v, want one rejected event then UPDATE and DELETE", events)
+	}
+	items, err := m.ListLongMemory(testUser)
+	if err != nil {
+		t.Fatalf("ListLongMemory: %v", err)
+	}
+	if len(items) != 2 || findMemory(t, m, "住在北京") == nil || findMemory(t, m, "喜欢游泳") != nil {
+		t.Fatalf("got memories %+v, want name and the updated city", items)
+	}
+	city := findMemory(t, m, "住在北京")
+	history, err := m.History(testUser, city.ID)
+	if err != nil {
+		t.Fatalf("History: %v", err)
+	}
+	if len(history) != 2 || history[1].Event != "UPDATE" || history[1].OldText != "住在上海" {
+		t.Fatalf("got history %+v, want ADD then UPDATE", history)
+	}
+	runs, err := m.ListExtractionRuns(testUser, testSession)
+	if err != nil {
+		t.Fatalf("ListExtractionRuns: %v", err)
+	}
+	if len(runs) != 2 {
+		t.Fatalf("got %d runs, want 2", len(runs))
+	}
+}
+
+// 从提示词中 "-ID: 1, 内容: ..." 一行取出记忆的临时编号
+func memoryAlias(line string) string {
+	_, rest, _ := strings.Cut(line, "-ID: ")
+	alias, _, _ := strings.Cut(rest, ",")
+	return alias
+}
+
+func TestSaveLongMemoryCompletesInterruptedWrite(t *testing.T) {
+	chat := llmtest.NewScriptedChatModel()
+	m := newTestMemorySystem(t, chat)
+	handler := m.LongMemoryHandler
+	ctx := context.Background()
+	addTurn(t, m, "我叫小明 住在上海", "你好小明")
+
+	chat.Push(
+		llmtest.Response{Content: factsResponse(t,
+			model.Fact{Content: "我叫小明", About: "user"},
+			model.Fact{Content: "住在上海", About: "user"},
+		)},
+		llmtest.Response{Content: memoryResponse(t,
+			model.MemoryEvent{Text: "我叫小明", Event: "ADD"},
+			model.MemoryEvent{Text: "住在上海", Event: "ADD"},
+		)},
+	)
+	embed := handler.vector.BatchEmbeddingFunc
+	calls := 0
+	handler.vector.BatchEmbeddingFunc = func(ctx context.Context, texts []string) ([][]float32, error) {
+		calls++
+		if calls == 3 {
+			return nil, errors.New("embedding unavailable")
+		}
+		return embed(ctx, texts)
+	}
+	if _, err := handler.SaveLongMemory(ctx, testUser, testSession); err == nil {
+		t.Fatal("SaveLongMemory succeeded, want embedding error")
+	}
+
+	// 模拟写入变更记录后进程退出 记录存在但记忆没有写入向量库
+	run, err := handler.sqlHandler.GetUnfinishedExtractionRun(testUser, testSession, 0)
+	if err != nil || run == nil {
+		t.Fatalf("GetUnfinishedExtractionRun = %v, %v, want the interrupted run", run, err)
+	}
+	var plan extractionPlan
+	if err := json.Unmarshal([]byte(run.Plan), &plan); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+	lost := plan.Events[1]
+	source := memorySource{SessionID: testSession, MessageIDs: plan.MessageIDs, RunID: run.ID}
+	err = handler.sqlHandler.AddMemoryHistory(&model.MemoryHistory{
+		MemoryID:        lost.ID,
+		UserID:          testUser,
+		SessionID:       testSession,
+		Version:         1,
+		Event:           "ADD",
+		NewText:         lost.Text,
+		NewMeta:         handler.stampMeta(testUser, nil, lost.Meta, source, 1, lost.Importance),
+		ExtractionRunID: run.ID,
+	})
+	if err != nil {
+		t.Fatalf("AddMemoryHistory: %v", err)
+	}
+
+	if _, err := handler.SaveLongMemory(ctx, testUser, testSession); err != nil {
+		t.Fatalf("SaveLongMemory retry: %v", err)
+	}
+	item := findMemory(t, m, lost.Text)
+	if item == nil || item.ID != lost.ID {
+		t.Fatalf("got memory %+v, want the interrupted ADD completed", item)
+	}
+	history, err := m.History(testUser, lost.ID)
+	if err != nil {
+		t.Fatalf("History: %v", err)
+	}
+	if len(history) != 1 {
+		t.Fatalf("got history %+v, want the single ADD", history)
+	}
+
+	// DELETE 的记录已写入而记忆仍在时 补齐删除
+	err = handler.completeHistory(ctx, &model.MemoryHistory{MemoryID: lost.ID, UserID: testUser, Event: "DELETE"})
+	if err != nil {
+		t.Fatalf("completeHistory: %v", err)
+	}
+	if findMemory(t, m, lost.Text) != nil {
+		t.Fatal("want the interrupted DELETE completed")
+	}
+}
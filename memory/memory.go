@@ -1,14 +1,22 @@
 package memory
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
 	"time"
 
 	"github.com/sashabaranov/go-openai"
+	"github.com/sirupsen/logrus"
 	"github.com/xuanlv2002/miniMem0/config"
 	"github.com/xuanlv2002/miniMem0/db/sqldb"
 	"github.com/xuanlv2002/miniMem0/db/vector"
 	"github.com/xuanlv2002/miniMem0/llm"
 	"github.com/xuanlv2002/miniMem0/model"
+	"github.com/xuanlv2002/miniMem0/prompt"
+	"github.com/xuanlv2002/miniMem0/rerank"
+	"github.com/xuanlv2002/miniMem0/tokenizer"
 )
 
 // 原始记忆结构体 包含角色 内容 时间
@@ -19,15 +27,28 @@ type MemorySystem struct {
 	ContextMemoryHandler *ContextMemoryHandler
 	sqlHandler           *sqldb.SqlHandler
 	vectorHandler        *vector.Vector
+	embedder             llm.Embedder
+	tokenizer            tokenizer.Tokenizer
+	budgetConfig         *config.PromptBudgetConfig
+	templates            *prompt.Templates
+	timeouts             *config.TimeoutConfig
+	queryRewrite         *config.QueryRewriteConfig
+	jobQueue             *JobQueue
+	callbackMu           sync.RWMutex
+	onMemoryUpdated      func(update *model.MemoryUpdate)
 }
 
+// 使用配置中的 OpenAI 兼容接口初始化记忆系统
 func NewMemorySystem(options *config.Config) (*MemorySystem, error) {
 	// 初始化LLM
 	llmModel := llm.NewLLM(options.GetChatConfig())
-	// // 初始化Embedding
-	embeddingModel := llm.NewEmbedding(options.GetEmbeddingConfig())
-	// 初始化向量数据库
-	vectorDB, err := vector.NewVector(options.GetVectorConfig(), embeddingModel.GetEmbeddingFunc())
+	return NewMemorySystemWithChatModel(options, llmModel)
+}
+
+// 使用自定义的大模型初始化记忆系统 用于接入非 OpenAI 的模型或测试
+func NewMemorySystemWithChatModel(options *config.Config, llmModel llm.ChatModel) (*MemorySystem, error) {
+	// 加载并校验提示词模板
+	templates, err := prompt.Load(options.GetPromptConfig())
 	if err != nil {
 		return nil, err
 	}
@@ -36,92 +57,443 @@ func NewMemorySystem(options *config.Config) (*MemorySystem, error) {
 	if err != nil {
 		return nil, err
 	}
+	// 初始化Embedding
+	embeddingConfig := options.GetEmbeddingConfig()
+	embeddingModel, err := llm.NewEmbedder(embeddingConfig)
+	if err != nil {
+		return nil, err
+	}
+	// 向量模型的名称 用于区分缓存和写入记忆元数据
+	embeddingName := string(embeddingConfig.Model)
+	if embeddingConfig.Provider == llm.EmbeddingProviderLocal {
+		embeddingName = llm.EmbeddingProviderLocal
+	}
+	if embeddingConfig.Cache {
+		// 向量缓存存储在SQL数据库中
+		embeddingModel = llm.NewCachedEmbedder(embeddingModel, sqlHandler, embeddingName, embeddingConfig.Dimensions, embeddingConfig.CacheSize)
+	}
+	// 初始化向量数据库
+	vectorDB, err := vector.NewVector(options.GetVectorConfig(), llm.EmbeddingFunc(embeddingModel))
+	if err != nil {
+		return nil, err
+	}
+	vectorDB.BatchEmbeddingFunc = embeddingModel.EmbedBatch
 	// 初始化记忆上下文系统
-	contextMemoryHandler := NewContextMemoryHandler(options.GetMemoryContextConfig(), sqlHandler, llmModel)
+	timeouts := options.GetTimeoutConfig()
+	contextMemoryHandler := NewContextMemoryHandler(options.GetMemoryContextConfig(), sqlHandler, llmModel, templates, timeouts)
+	// 校验长期记忆的淘汰方式
+	switch options.GetLongMemoryConfig().Eviction {
+	case "", EvictionDelete, EvictionArchive:
+	default:
+		return nil, fmt.Errorf("unsupported long memory eviction: %s", options.GetLongMemoryConfig().Eviction)
+	}
 	// 初始化长期记忆系统。
-	longMemoryHandler := NewLongMemory(options.GetLongMemoryConfig(), vectorDB, sqlHandler, llmModel)
+	longMemoryHandler := NewLongMemory(options.GetLongMemoryConfig(), vectorDB, sqlHandler, llmModel, templates, timeouts, embeddingName, options.GetGraphMemoryConfig())
+	// 初始化检索结果的重排序
+	rerankConfig := options.GetRerankConfig()
+	reranker, err := rerank.New(rerankConfig, llmModel, templates)
+	if err != nil {
+		return nil, err
+	}
+	longMemoryHandler.SetReranker(reranker, rerankConfig)
 	// 初始化短期记忆系统
 	shortMemoryHandler := NewShortMemoryHandler(options.GetShortMemoryConfig(), sqlHandler)
+	// 初始化后台记忆任务队列
+	jobQueue := NewJobQueue(options.GetJobConfig(), sqlHandler, map[string]JobHandler{
+		model.JobTypeSummarize: func(ctx context.Context, userID, sessionID string) ([]model.MemoryEvent, error) {
+			return nil, contextMemoryHandler.SummaryContextMemory(ctx, userID, sessionID)
+		},
+		model.JobTypeExtract: longMemoryHandler.SaveLongMemory,
+	})
 
-	return &MemorySystem{
+	m := &MemorySystem{
 		ContextMemoryHandler: contextMemoryHandler,
 		LongMemoryHandler:    longMemoryHandler,
 		ShortMemoryHandler:   shortMemoryHandler,
 		sqlHandler:           sqlHandler,
 		vectorHandler:        vectorDB,
-	}, nil
+		embedder:             embeddingModel,
+		tokenizer:            tokenizer.HeuristicTokenizer{},
+		budgetConfig:         options.GetPromptBudgetConfig(),
+		templates:            templates,
+		timeouts:             timeouts,
+		queryRewrite:         options.GetQueryRewriteConfig(),
+		jobQueue:             jobQueue,
+	}
+	jobQueue.OnResult(m.handleJobResult)
+	if err := jobQueue.Start(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// 设置估算提示词token数量的分词器 默认使用启发式估算
+func (m *MemorySystem) SetTokenizer(tok tokenizer.Tokenizer) {
+	m.tokenizer = tok
+}
+
+// 设置长期记忆检索结果的重排序器 默认根据 RERANK 配置创建 为nil时不重排序
+func (m *MemorySystem) SetReranker(reranker rerank.Reranker) {
+	m.LongMemoryHandler.SetReranker(reranker, m.LongMemoryHandler.rerankConfig)
+}
+
+// 返回向量缓存的命中和未命中次数 未开启缓存时均为0
+func (m *MemorySystem) EmbeddingCacheStats() (hits, misses int64) {
+	if cached, ok := m.embedder.(*llm.CachedEmbedder); ok {
+		return cached.Stats()
+	}
+	return 0, 0
 }
 
-func (m *MemorySystem) FlushMemory() error {
-	// 手动触发记忆更新
-	m.ContextMemoryHandler.UpdateContextMemory()
-	m.LongMemoryHandler.UpdateLongMemory()
+// 立即更新会话的记忆并等待完成
+// 先等待会话中已在队列的任务 再同步执行上下文总结和长期记忆抽取 返回执行中的错误
+func (m *MemorySystem) FlushMemory(userID, sessionID string) error {
+	return m.FlushMemoryContext(context.Background(), userID, sessionID)
+}
+
+// 同 FlushMemory ctx 取消时停止等待和正在进行的模型请求
+func (m *MemorySystem) FlushMemoryContext(ctx context.Context, userID, sessionID string) error {
+	if err := m.jobQueue.WaitIdle(ctx, userID, sessionID); err != nil {
+		return err
+	}
+	summaryErr := m.ContextMemoryHandler.SummaryContextMemory(ctx, userID, sessionID)
+	events, err := m.LongMemoryHandler.SaveLongMemory(ctx, userID, sessionID)
+	update := &model.MemoryUpdate{UserID: userID, SessionID: sessionID, Err: err}
+	update.AddEvents(events)
+	m.notifyMemoryUpdated(update)
+	return errors.Join(summaryErr, err)
+}
 
-	// 等待所有记忆处理完成
+// 等待所有正在进行和已到期的记忆更新完成 等待退避重试的任务不会阻塞
+func (m *MemorySystem) WaitDone() {
+	if err := m.jobQueue.WaitIdle(context.Background(), "", ""); err != nil {
+		logrus.Errorf("wait memory jobs error: %v", err)
+	}
 	m.ContextMemoryHandler.WaitDone()
 	m.LongMemoryHandler.WaitDone()
-	return nil
 }
 
-// 处理大模型输入内容
-func (m *MemorySystem) ProcessInput(input string) (string, error) {
+// 停止后台记忆任务 中断执行中的任务并等待它们退出 被中断和未执行的任务在下次启动时继续
+func (m *MemorySystem) Close() {
+	m.jobQueue.Stop()
+}
+
+// 列出后台记忆任务 userID 和 status 为空时不过滤
+func (m *MemorySystem) ListJobs(userID, status string) ([]model.MemoryJob, error) {
+	return m.jobQueue.List(userID, status)
+}
+
+// 重新执行用户失败的后台记忆任务
+func (m *MemorySystem) RetryJob(userID string, id int64) (*model.MemoryJob, error) {
+	return m.jobQueue.Retry(userID, id)
+}
+
+// 处理大模型输入内容 短期记忆和摘要按会话隔离 长期记忆按用户隔离
+func (m *MemorySystem) ProcessInput(userID, sessionID, input string) (string, error) {
+	return m.ProcessInputContext(context.Background(), userID, sessionID, input)
+}
+
+// 同 ProcessInput ctx 取消或超过 TIMEOUT.INPUT 时停止检索并返回错误 本次输入不会被存储
+func (m *MemorySystem) ProcessInputContext(ctx context.Context, userID, sessionID, input string) (string, error) {
+	prompt, _, err := m.ProcessInputWithUsageContext(ctx, userID, sessionID, input)
+	return prompt, err
+}
+
+// 处理大模型输入内容 并返回提示词各部分的token用量
+// 超出 PROMPT_BUDGET 时按优先级裁剪记忆 用户输入始终完整存储
+func (m *MemorySystem) ProcessInputWithUsage(userID, sessionID, input string) (string, *model.TokenUsage, error) {
+	return m.ProcessInputWithUsageContext(context.Background(), userID, sessionID, input)
+}
+
+// 同 ProcessInputWithUsage 使用调用方的ctx
+func (m *MemorySystem) ProcessInputWithUsageContext(ctx context.Context, userID, sessionID, input string) (string, *model.TokenUsage, error) {
+	sections, usage, err := m.processInput(ctx, userID, sessionID, input)
+	if err != nil {
+		return "", nil, err
+	}
+	return sections.String(), usage, nil
+}
+
+// 处理大模型输入内容 以结构化的形式返回记忆
+// 调用方可以自行决定记忆放在系统提示词还是对话消息中 也可以通过 MemoryContext.ToMessages 直接得到消息列表
+func (m *MemorySystem) ProcessInputStructured(userID, sessionID, input string) (*model.MemoryContext, error) {
+	return m.ProcessInputStructuredContext(context.Background(), userID, sessionID, input)
+}
+
+// 同 ProcessInputStructured 使用调用方的ctx
+func (m *MemorySystem) ProcessInputStructuredContext(ctx context.Context, userID, sessionID, input string) (*model.MemoryContext, error) {
+	sections, usage, err := m.processInput(ctx, userID, sessionID, input)
+	if err != nil {
+		return nil, err
+	}
+	shortMemories := make([]openai.ChatCompletionMessage, 0, len(sections.short.Memorys))
+	for _, memory := range sections.short.Memorys {
+		shortMemories = append(shortMemories, openai.ChatCompletionMessage{
+			Role:    string(memory.Role),
+			Content: memory.Content,
+		})
+	}
+	return &model.MemoryContext{
+		Summary:       sections.context.Summary,
+		LongMemories:  sections.long.VectorMemorys,
+		Relations:     sections.long.Relations,
+		ShortMemories: shortMemories,
+		Input:         sections.input.Content,
+		Usage:         usage,
+		Queries:       sections.long.Queries,
+		Templates:     sections.templates,
+	}, nil
+}
+
+// 获取会话的各类记忆并按预算裁剪 然后存储本次输入
+func (m *MemorySystem) processInput(ctx context.Context, userID, sessionID, input string) (*promptSections, *model.TokenUsage, error) {
+	ctx, cancel := withTimeout(ctx, m.timeouts.Input)
+	defer cancel()
+
 	// 传入激活内容
 	activeMemory := &model.OriginalMemory{
+		UserID:    userID,
+		SessionID: sessionID,
 		Role:      openai.ChatMessageRoleUser,
 		Content:   input,
 		CreatedAt: time.Now(),
 	}
 
 	// 获得完整短期记忆
-	shortMemory, err := m.ShortMemoryHandler.GetShortMemory()
+	shortMemory, err := m.ShortMemoryHandler.GetShortMemory(ctx, userID, sessionID)
 	if err != nil {
-		return "", err
+		return nil, nil, err
 	}
 
 	// 获得上下文记忆
-	contextMemory, err := m.ContextMemoryHandler.GetContextMemory()
+	contextMemory, err := m.ContextMemoryHandler.GetContextMemory(ctx, userID, sessionID)
 	if err != nil {
-		return "", err
+		return nil, nil, err
+	}
+
+	// 按本次输入和近期对话选择模板语言
+	contents := []string{input}
+	for _, memory := range shortMemory.Memorys {
+		contents = append(contents, memory.Content)
+	}
+	templates := m.templates.Detect(contents...)
+
+	// 结合近期对话改写检索语句 失败时使用原始输入检索
+	queries := []string{activeMemory.Content}
+	if m.queryRewrite.Enable {
+		rewritten, err := m.LongMemoryHandler.RewriteQuery(ctx, templates, activeMemory.Content, shortMemory, contextMemory, m.queryRewrite.MaxQueries)
+		if err != nil {
+			logrus.Warnf("rewrite query failed, search with raw input: %v", err)
+		} else {
+			queries = rewritten
+		}
 	}
 
 	// 获得长期记忆
-	longMemory, err := m.LongMemoryHandler.GetLongMemory(activeMemory.Content)
+	longMemory, err := m.LongMemoryHandler.GetLongMemoryQueries(ctx, userID, queries, SearchOptions{})
 	if err != nil {
-		return "", err
+		return nil, nil, err
 	}
 
-	// 返回拼接后的prompt
-	prompt := contextMemory.GetPrompt() + longMemory.GetPrompt() + shortMemory.GetPrompt() + "#用户输入: \n" + activeMemory.GetPrompt()
+	// 按预算裁剪
+	promptInput := *activeMemory
+	sections := &promptSections{
+		templates: templates,
+		context:   contextMemory,
+		long:      longMemory,
+		short:     shortMemory,
+		input:     &promptInput,
+	}
+	budget := m.budgetConfig
+	truncated := sections.fit(m.tokenizer, budget)
+	usage := sections.usage(m.tokenizer, budget.Total)
+	usage.Truncated = truncated
 
 	// 将瞬时记忆存储 OriginalMemory
 	err = m.sqlHandler.AddOriginalMemory(activeMemory)
 	if err != nil {
-		return "", err
+		return nil, nil, err
 	}
-	return prompt, nil
+	return sections, usage, nil
 }
 
-// 处理大模型输出内容
-func (m *MemorySystem) ProcessOutput(ouput string) error {
-	// 将模型输出存储短期记忆
-	outputMemory := &model.OriginalMemory{
-		Role:      openai.ChatMessageRoleAssistant,
-		Content:   ouput,
-		CreatedAt: time.Now(),
-	}
-	err := m.sqlHandler.AddOriginalMemory(outputMemory)
-	if err != nil {
+// 获得会话的短期记忆
+func (m *MemorySystem) GetShortMemory(userID, sessionID string) (*model.ShortMemory, error) {
+	return m.GetShortMemoryContext(context.Background(), userID, sessionID)
+}
+
+// 同 GetShortMemory 使用调用方的ctx
+func (m *MemorySystem) GetShortMemoryContext(ctx context.Context, userID, sessionID string) (*model.ShortMemory, error) {
+	return m.ShortMemoryHandler.GetShortMemory(ctx, userID, sessionID)
+}
+
+// 获得会话的上下文摘要记忆
+func (m *MemorySystem) GetContextMemory(userID, sessionID string) (*model.ContextMemory, error) {
+	return m.GetContextMemoryContext(context.Background(), userID, sessionID)
+}
+
+// 同 GetContextMemory 使用调用方的ctx
+func (m *MemorySystem) GetContextMemoryContext(ctx context.Context, userID, sessionID string) (*model.ContextMemory, error) {
+	return m.ContextMemoryHandler.GetContextMemory(ctx, userID, sessionID)
+}
+
+// 获得用户与文本相关的长期记忆
+func (m *MemorySystem) GetLongMemory(userID, text string) (*model.LongMemory, error) {
+	return m.GetLongMemoryContext(context.Background(), userID, text)
+}
+
+// 同 GetLongMemory 使用调用方的ctx
+func (m *MemorySystem) GetLongMemoryContext(ctx context.Context, userID, text string) (*model.LongMemory, error) {
+	return m.LongMemoryHandler.GetLongMemory(ctx, userID, text, SearchOptions{})
+}
+
+// 长期记忆的查询选项 见 vector.SearchOptions
+type SearchOptions = vector.SearchOptions
+
+// 按条件搜索用户的相关长期记忆 如只搜索关于某人的记忆 opts.Where 中的 user_id 会被忽略
+func (m *MemorySystem) SearchLongMemory(userID, text string, opts SearchOptions) (*model.LongMemory, error) {
+	return m.SearchLongMemoryContext(context.Background(), userID, text, opts)
+}
+
+// 同 SearchLongMemory 使用调用方的ctx
+func (m *MemorySystem) SearchLongMemoryContext(ctx context.Context, userID, text string, opts SearchOptions) (*model.LongMemory, error) {
+	return m.LongMemoryHandler.GetLongMemory(ctx, userID, text, opts)
+}
+
+// 列出用户的所有长期记忆
+func (m *MemorySystem) ListLongMemory(userID string) ([]model.LongMemoryItem, error) {
+	return m.ListLongMemoryContext(context.Background(), userID)
+}
+
+// 同 ListLongMemory 使用调用方的ctx
+func (m *MemorySystem) ListLongMemoryContext(ctx context.Context, userID string) ([]model.LongMemoryItem, error) {
+	return m.LongMemoryHandler.ListLongMemory(ctx, userID)
+}
+
+// 列出用户因超出 MAX_MEMORIES_PER_USER 被归档的长期记忆 可以通过 Rollback 恢复
+func (m *MemorySystem) ListArchivedMemory(userID string) ([]model.LongMemoryItem, error) {
+	return m.ListArchivedMemoryContext(context.Background(), userID)
+}
+
+// 同 ListArchivedMemory 使用调用方的ctx
+func (m *MemorySystem) ListArchivedMemoryContext(ctx context.Context, userID string) ([]model.LongMemoryItem, error) {
+	return m.LongMemoryHandler.ListArchivedMemory(ctx, userID)
+}
+
+// 列出用户的所有关系记忆 未开启 GRAPH_MEMORY 时为空
+func (m *MemorySystem) ListRelations(userID string) ([]model.Relation, error) {
+	return m.ListRelationsContext(context.Background(), userID)
+}
+
+// 同 ListRelations 使用调用方的ctx
+func (m *MemorySystem) ListRelationsContext(ctx context.Context, userID string) ([]model.Relation, error) {
+	return m.LongMemoryHandler.ListRelations(ctx, userID)
+}
+
+// 删除用户的长期记忆
+func (m *MemorySystem) DeleteLongMemory(userID string, memoryIDs ...string) error {
+	return m.DeleteLongMemoryContext(context.Background(), userID, memoryIDs...)
+}
+
+// 同 DeleteLongMemory 使用调用方的ctx
+func (m *MemorySystem) DeleteLongMemoryContext(ctx context.Context, userID string, memoryIDs ...string) error {
+	return m.LongMemoryHandler.DeleteLongMemory(ctx, userID, memoryIDs...)
+}
+
+// 获得用户某条长期记忆的变更记录 按时间从早到晚排序
+func (m *MemorySystem) History(userID, memoryID string) ([]model.MemoryHistory, error) {
+	return m.HistoryContext(context.Background(), userID, memoryID)
+}
+
+// 同 History 使用调用方的ctx
+func (m *MemorySystem) HistoryContext(ctx context.Context, userID, memoryID string) ([]model.MemoryHistory, error) {
+	return m.LongMemoryHandler.History(ctx, userID, memoryID)
+}
+
+// 把用户的一条长期记忆回滚到指定版本 版本号见 History
+func (m *MemorySystem) Rollback(userID, memoryID string, toVersion int) error {
+	return m.RollbackContext(context.Background(), userID, memoryID, toVersion)
+}
+
+// 同 Rollback 使用调用方的ctx
+func (m *MemorySystem) RollbackContext(ctx context.Context, userID, memoryID string, toVersion int) error {
+	return m.LongMemoryHandler.Rollback(ctx, userID, memoryID, toVersion)
+}
+
+// 列出用户的长期记忆抽取 sessionID 为空时列出所有会话
+func (m *MemorySystem) ListExtractionRuns(userID, sessionID string) ([]model.ExtractionRun, error) {
+	return m.ListExtractionRunsContext(context.Background(), userID, sessionID)
+}
+
+// 同 ListExtractionRuns 使用调用方的ctx
+func (m *MemorySystem) ListExtractionRunsContext(ctx context.Context, userID, sessionID string) ([]model.ExtractionRun, error) {
+	return m.LongMemoryHandler.ListExtractionRuns(ctx, userID, sessionID)
+}
+
+// 撤销一次长期记忆抽取的所有变更 resetCursor 为真时会话的抽取进度退回到抽取前
+func (m *MemorySystem) RevertExtraction(userID string, runID int64, resetCursor bool) (*model.ExtractionRun, error) {
+	return m.RevertExtractionContext(context.Background(), userID, runID, resetCursor)
+}
+
+// 同 RevertExtraction 使用调用方的ctx
+func (m *MemorySystem) RevertExtractionContext(ctx context.Context, userID string, runID int64, resetCursor bool) (*model.ExtractionRun, error) {
+	return m.LongMemoryHandler.RevertExtraction(ctx, userID, runID, resetCursor)
+}
+
+// 处理大模型输出内容 存储输出后添加记忆更新任务 不等待任务执行
+func (m *MemorySystem) ProcessOutput(userID, sessionID, ouput string) error {
+	return m.ProcessOutputContext(context.Background(), userID, sessionID, ouput)
+}
+
+// 同 ProcessOutput ctx 只作用于存储和添加任务 后台任务不跟随调用方的ctx 受 TIMEOUT 中各阶段的限制
+func (m *MemorySystem) ProcessOutputContext(ctx context.Context, userID, sessionID, ouput string) error {
+	if err := m.saveOutput(ctx, userID, sessionID, ouput); err != nil {
 		return err
 	}
 
-	// 更新上下文记忆
-	m.ContextMemoryHandler.UpdateContextMemory()
+	// 只添加后台任务 由任务队列异步更新上下文记忆和长期记忆
+	return errors.Join(
+		m.jobQueue.Enqueue(model.JobTypeSummarize, userID, sessionID),
+		m.jobQueue.Enqueue(model.JobTypeExtract, userID, sessionID),
+	)
+}
 
-	// 更新长期记忆
-	m.LongMemoryHandler.UpdateLongMemory()
+// 处理大模型输出内容 存储输出后立即返回 返回的 MemoryUpdateFuture 在本次触发的记忆更新完成后得到结果
+// 与其他输出触发的同一会话的更新合并执行时 得到的是合并后的结果
+func (m *MemorySystem) ProcessOutputAsync(userID, sessionID, output string) (*MemoryUpdateFuture, error) {
+	if err := m.saveOutput(context.Background(), userID, sessionID, output); err != nil {
+		return nil, err
+	}
+	summaryDone, err := m.jobQueue.EnqueueWait(model.JobTypeSummarize, userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	extractDone, err := m.jobQueue.EnqueueWait(model.JobTypeExtract, userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return newMemoryUpdateFuture(userID, sessionID, summaryDone, extractDone), nil
+}
 
-	// 等待所有记忆处理完成
-	m.ContextMemoryHandler.WaitDone()
-	m.LongMemoryHandler.WaitDone()
-	return nil
+// 将模型输出存储为原始记忆
+func (m *MemorySystem) saveOutput(ctx context.Context, userID, sessionID, output string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	outputMemory := &model.OriginalMemory{
+		UserID:    userID,
+		SessionID: sessionID,
+		Role:      openai.ChatMessageRoleAssistant,
+		Content:   output,
+		CreatedAt: time.Now(),
+	}
+	return m.sqlHandler.AddOriginalMemory(outputMemory)
+}
+
+// d 大于0时为ctx设置超时
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
 }
@@ -0,0 +1,93 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/xuanlv2002/miniMem0/config"
+	"github.com/xuanlv2002/miniMem0/llm"
+	"github.com/xuanlv2002/miniMem0/model"
+)
+
+const (
+	testUser    = "u1"
+	testSession = "s1"
+)
+
+// 使用临时目录 离线向量和给定的假模型创建记忆系统
+// 每2条记录总结和抽取一次 mutate 可以修改默认配置
+func newTestMemorySystem(t *testing.T, chat llm.ChatModel, mutate ...func(cfg *config.Config)) *MemorySystem {
+	t.Helper()
+	dir := t.TempDir()
+	cfg := &config.Config{
+		EmbeddingConfig: &config.EmbeddingConfig{Provider: llm.EmbeddingProviderLocal},
+		VectorConfig: &config.VectorConfig{
+			Path:       filepath.Join(dir, "vector"),
+			Collection: "test",
+			TopK:       10,
+		},
+		SqlConfig:           &config.SqlConfig{Path: filepath.Join(dir, "memory.db")},
+		MemoryContextConfig: &config.ContextMemoryConfig{SummaryGap: 2},
+		LongMemoryConfig:    &config.LongMemoryConfig{LongGap: 2},
+		ShortMemoryConfig:   &config.ShortMemoryConfig{ShortWindow: 6},
+		PromptBudgetConfig:  &config.PromptBudgetConfig{},
+		PromptConfig:        &config.PromptConfig{Language: "zh"},
+	}
+	for _, fn := range mutate {
+		fn(cfg)
+	}
+	m, err := NewMemorySystemWithChatModel(cfg, chat)
+	if err != nil {
+		t.Fatalf("NewMemorySystemWithChatModel: %v", err)
+	}
+	t.Cleanup(m.Close)
+	return m
+}
+
+// 写入一轮对话 不触发后台任务 由测试直接调用总结和抽取
+func addTurn(t *testing.T, m *MemorySystem, input, output string) {
+	t.Helper()
+	if _, err := m.ProcessInput(testUser, testSession, input); err != nil {
+		t.Fatalf("ProcessInput: %v", err)
+	}
+	if err := m.saveOutput(context.Background(), testUser, testSession, output); err != nil {
+		t.Fatalf("saveOutput: %v", err)
+	}
+}
+
+// 把模型的回复编码为JSON
+func mustJSON(t *testing.T, v any) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+	return string(data)
+}
+
+// 事实抽取的回复
+func factsResponse(t *testing.T, facts ...model.Fact) string {
+	return mustJSON(t, map[string]any{"facts": facts})
+}
+
+// 记忆处理的回复
+func memoryResponse(t *testing.T, events ...model.MemoryEvent) string {
+	return mustJSON(t, map[string]any{"memory": events})
+}
+
+// 按内容查找用户的长期记忆
+func findMemory(t *testing.T, m *MemorySystem, text string) *model.LongMemoryItem {
+	t.Helper()
+	items, err := m.ListLongMemory(testUser)
+	if err != nil {
+		t.Fatalf("ListLongMemory: %v", err)
+	}
+	for i := range items {
+		if items[i].Text == text {
+			return &items[i]
+		}
+	}
+	return nil
+}
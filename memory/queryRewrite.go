@@ -0,0 +1,64 @@
+package memory
+
+import (
+	"context"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/xuanlv2002/miniMem0/model"
+	"github.com/xuanlv2002/miniMem0/prompt"
+)
+
+/*
+	检索长期记忆前改写查询
+	"那她喜欢什么？" 这类追问指代的对象在短期记忆中 直接检索找不到有用的记忆
+	结合短期记忆和上下文摘要 把输入改写为一条或多条独立的检索语句
+*/
+
+// 把用户输入改写为独立的检索语句 最多返回 maxQueries 条 0表示不限制
+// 模型没有返回语句时使用原始输入
+func (l *LongMemoryHandler) RewriteQuery(ctx context.Context, templates *prompt.Templates, input string, shortMemory *model.ShortMemory, contextMemory *model.ContextMemory, maxQueries int) ([]string, error) {
+	data := prompt.QueryRewriteInputData{
+		Summary: contextMemory.Summary,
+		Input:   input,
+	}
+	for _, memory := range shortMemory.Memorys {
+		data.Turns = append(data.Turns, memory.Turn())
+	}
+
+	var response struct {
+		Queries []string `json:"queries"`
+	}
+	err := l.chatJSON(ctx, templates, []openai.ChatCompletionMessage{
+		{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: templates.System(prompt.QueryRewriteTemplate),
+		},
+		{
+			Role:    openai.ChatMessageRoleUser,
+			Content: templates.Render(prompt.QueryRewriteInputTemplate, data),
+		},
+	}, queriesSchema, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	// 去掉空白和重复的语句
+	queries := make([]string, 0, len(response.Queries))
+	seen := make(map[string]bool, len(response.Queries))
+	for _, query := range response.Queries {
+		query = strings.TrimSpace(query)
+		if query == "" || seen[query] {
+			continue
+		}
+		seen[query] = true
+		queries = append(queries, query)
+		if maxQueries > 0 && len(queries) >= maxQueries {
+			break
+		}
+	}
+	if len(queries) == 0 {
+		return []string{input}, nil
+	}
+	return queries, nil
+}
@@ -1,6 +1,8 @@
 package memory
 
 import (
+	"context"
+
 	"github.com/xuanlv2002/miniMem0/config"
 	"github.com/xuanlv2002/miniMem0/db/sqldb"
 	"github.com/xuanlv2002/miniMem0/model"
@@ -27,9 +29,9 @@ func NewShortMemoryHandler(config *config.ShortMemoryConfig, sqlHandler *sqldb.S
 	}
 }
 
-// 拉取短期记忆
-func (s *ShortMemroyHandler) GetShortMemory() (*model.ShortMemory, error) {
-	shortMemroy, _, err := s.sqlHandler.GetLastOriginalMemory(s.config.ShortWindow)
+// 拉取会话的短期记忆
+func (s *ShortMemroyHandler) GetShortMemory(ctx context.Context, userID, sessionID string) (*model.ShortMemory, error) {
+	shortMemroy, _, err := s.sqlHandler.WithContext(ctx).GetLastOriginalMemory(userID, sessionID, s.config.ShortWindow)
 	if err != nil {
 		return nil, err
 	}
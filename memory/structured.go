@@ -0,0 +1,174 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/sashabaranov/go-openai/jsonschema"
+	"github.com/sirupsen/logrus"
+	"github.com/xuanlv2002/miniMem0/llm"
+	"github.com/xuanlv2002/miniMem0/prompt"
+)
+
+/*
+	抽取和合并记忆时要求模型输出的JSON结构
+	模型支持结构化输出时按 Schema 约束输出 否则只依靠提示词约束
+	输出无法解析时把错误发回给模型重试
+*/
+
+// 事实抽取的输出 {"facts": [{"content", "appearTime", "about", "importance"}]}
+var factsSchema = &llm.OutputSchema{
+	Name:        "extract_facts",
+	Description: "从对话中抽取的事实",
+	Strict:      true,
+	Schema: jsonschema.Definition{
+		Type:                 jsonschema.Object,
+		Required:             []string{"facts"},
+		AdditionalProperties: false,
+		Properties: map[string]jsonschema.Definition{
+			"facts": {
+				Type: jsonschema.Array,
+				Items: &jsonschema.Definition{
+					Type:                 jsonschema.Object,
+					Required:             []string{"content", "appearTime", "about", "importance"},
+					AdditionalProperties: false,
+					Properties: map[string]jsonschema.Definition{
+						"content":    {Type: jsonschema.String, Description: "简洁完整的事实陈述"},
+						"appearTime": {Type: jsonschema.String, Description: "事实出现的时间"},
+						"about":      {Type: jsonschema.String, Description: "事实关于谁"},
+						"importance": {Type: jsonschema.Integer, Description: "事实的重要性 1到10"},
+					},
+				},
+			},
+		},
+	},
+}
+
+// 记忆处理的输出 {"memory": [{"id", "text", "event", "meta"}]}
+// meta 的键不固定 因此不使用严格模式
+var memorySchema = &llm.OutputSchema{
+	Name:        "process_memory",
+	Description: "合并新事实后的记忆操作",
+	Schema: jsonschema.Definition{
+		Type:     jsonschema.Object,
+		Required: []string{"memory"},
+		Properties: map[string]jsonschema.Definition{
+			"memory": {
+				Type: jsonschema.Array,
+				Items: &jsonschema.Definition{
+					Type:     jsonschema.Object,
+					Required: []string{"id", "text", "event"},
+					Properties: map[string]jsonschema.Definition{
+						"id":         {Type: jsonschema.String},
+						"text":       {Type: jsonschema.String},
+						"event":      {Type: jsonschema.String, Enum: []string{"ADD", "UPDATE", "DELETE", "NONE"}},
+						"meta":       {Type: jsonschema.Object, AdditionalProperties: jsonschema.Definition{Type: jsonschema.String}},
+						"old_memory": {Type: jsonschema.String},
+					},
+				},
+			},
+		},
+	},
+}
+
+// 检索查询改写的输出 {"queries": ["..."]}
+var queriesSchema = &llm.OutputSchema{
+	Name:        "rewrite_queries",
+	Description: "用于检索长期记忆的独立语句",
+	Strict:      true,
+	Schema: jsonschema.Definition{
+		Type:                 jsonschema.Object,
+		Required:             []string{"queries"},
+		AdditionalProperties: false,
+		Properties: map[string]jsonschema.Definition{
+			"queries": {
+				Type:  jsonschema.Array,
+				Items: &jsonschema.Definition{Type: jsonschema.String},
+			},
+		},
+	},
+}
+
+// 实体关系抽取的输出 {"entities": [{"name", "type"}], "relations": [{"source", "relation", "target"}], "delete": ["..."]}
+var graphSchema = &llm.OutputSchema{
+	Name:        "extract_graph",
+	Description: "从新事实中抽取的实体和关系",
+	Strict:      true,
+	Schema: jsonschema.Definition{
+		Type:                 jsonschema.Object,
+		Required:             []string{"entities", "relations", "delete"},
+		AdditionalProperties: false,
+		Properties: map[string]jsonschema.Definition{
+			"entities": {
+				Type: jsonschema.Array,
+				Items: &jsonschema.Definition{
+					Type:                 jsonschema.Object,
+					Required:             []string{"name", "type"},
+					AdditionalProperties: false,
+					Properties: map[string]jsonschema.Definition{
+						"name": {Type: jsonschema.String, Description: "实体名称 用户本人为 user"},
+						"type": {Type: jsonschema.String, Enum: []string{"person", "place", "organization", "thing", "event"}},
+					},
+				},
+			},
+			"relations": {
+				Type: jsonschema.Array,
+				Items: &jsonschema.Definition{
+					Type:                 jsonschema.Object,
+					Required:             []string{"source", "relation", "target"},
+					AdditionalProperties: false,
+					Properties: map[string]jsonschema.Definition{
+						"source":   {Type: jsonschema.String},
+						"relation": {Type: jsonschema.String},
+						"target":   {Type: jsonschema.String},
+					},
+				},
+			},
+			"delete": {
+				Type:  jsonschema.Array,
+				Items: &jsonschema.Definition{Type: jsonschema.String},
+			},
+		},
+	},
+}
+
+// 请求模型输出JSON并解析到 v 解析失败时带上错误信息重试 PARSE_RETRY 次
+func (l *LongMemoryHandler) chatJSON(ctx context.Context, templates *prompt.Templates, messages []openai.ChatCompletionMessage, schema *llm.OutputSchema, v any) error {
+	for attempt := 0; ; attempt++ {
+		content, err := l.chatStructured(ctx, messages, schema)
+		if err != nil {
+			return err
+		}
+		logrus.Debugf("%s output: %s", schema.Name, content)
+		err = llm.ParseJSON(content, v)
+		if err == nil {
+			return nil
+		}
+		if attempt >= l.config.ParseRetry {
+			return fmt.Errorf("failed to parse LLM response: %v", err)
+		}
+		logrus.Warnf("failed to parse %s output, retry %d: %v", schema.Name, attempt+1, err)
+		messages = append(messages, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleAssistant,
+			Content: content,
+		}, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleUser,
+			Content: templates.Render(prompt.ParseRetryTemplate, prompt.ParseRetryData{Error: err.Error()}),
+		})
+	}
+}
+
+// 模型支持时使用结构化输出 每次请求受 TIMEOUT.LLM 限制
+func (l *LongMemoryHandler) chatStructured(ctx context.Context, messages []openai.ChatCompletionMessage, schema *llm.OutputSchema) (string, error) {
+	ctx, cancel := withTimeout(ctx, l.timeouts.LLM)
+	defer cancel()
+	if structured, ok := l.llmHandler.(llm.StructuredChatModel); ok {
+		return structured.ChatStructured(ctx, messages, schema)
+	}
+	result, err := l.llmHandler.Chat(ctx, messages)
+	if err != nil {
+		return "", err
+	}
+	return result.Content, nil
+}
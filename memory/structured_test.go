@@ -0,0 +1,63 @@
+package memory
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/xuanlv2002/miniMem0/config"
+	"github.com/xuanlv2002/miniMem0/llm/llmtest"
+	"github.com/xuanlv2002/miniMem0/model"
+)
+
+func TestChatJSONParseRetry(t *testing.T) {
+	chat := llmtest.NewScriptedChatModel()
+	m := newTestMemorySystem(t, chat, func(cfg *config.Config) {
+		cfg.LongMemoryConfig.ParseRetry = 1
+	})
+	handler := m.LongMemoryHandler
+	templates := handler.templates.Detect("我叫小明")
+	ctx := context.Background()
+
+	// 第一次输出无法解析 带上错误信息重试后成功
+	malformed := `{"facts": [{"content": "我叫小明"`
+	chat.Push(
+		llmtest.Response{Content: malformed},
+		llmtest.Response{Content: "```json\n" + factsResponse(t, model.Fact{Content: "我叫小明", About: "user"}) + "\n```"},
+	)
+	facts, err := handler.ExtractFacts(ctx, templates, "user: 我叫小明")
+	if err != nil {
+		t.Fatalf("ExtractFacts: %v", err)
+	}
+	if len(facts) != 1 || facts[0].Content != "我叫小明" {
+		t.Fatalf("got facts %+v, want 我叫小明", facts)
+	}
+	calls := chat.Calls()
+	if len(calls) != 2 {
+		t.Fatalf("got %d calls, want 2", len(calls))
+	}
+	if calls[1].Schema != factsSchema {
+		t.Fatal("retry does not use the facts schema")
+	}
+	retry := calls[1].Messages
+	if len(retry) != len(calls[0].Messages)+2 {
+		t.Fatalf("got %d retry messages, want the first request with the output and the error", len(retry))
+	}
+	output, hint := retry[len(retry)-2], retry[len(retry)-1]
+	if output.Role != openai.ChatMessageRoleAssistant || output.Content != malformed {
+		t.Fatalf("got message %+v, want the malformed output", output)
+	}
+	if hint.Role != openai.ChatMessageRoleUser || !strings.Contains(hint.Content, "incomplete JSON object") {
+		t.Fatalf("got message %+v, want the parse error", hint)
+	}
+
+	// 重试次数用完后返回解析错误
+	chat.Push(llmtest.Response{Content: malformed}, llmtest.Response{Content: "没有JSON"})
+	if _, err := handler.ExtractFacts(ctx, templates, "user: 我叫小明"); err == nil || !strings.Contains(err.Error(), "failed to parse") {
+		t.Fatalf("ExtractFacts error = %v, want a parse error", err)
+	}
+	if chat.Remaining() != 0 || len(chat.Calls()) != 4 {
+		t.Fatalf("got %d calls, want 4", len(chat.Calls()))
+	}
+}
@@ -0,0 +1,79 @@
+package memory
+
+import (
+	"errors"
+
+	"github.com/xuanlv2002/miniMem0/model"
+)
+
+/*
+	后台记忆更新的结果通知
+	ProcessOutputAsync 返回 MemoryUpdateFuture 等待单次输出触发的更新
+	OnMemoryUpdated 注册的回调会收到每一次长期记忆更新的结果
+*/
+
+// 一次输出触发的后台记忆更新
+type MemoryUpdateFuture struct {
+	done   chan struct{}
+	update *model.MemoryUpdate
+}
+
+func newMemoryUpdateFuture(userID, sessionID string, results ...<-chan JobResult) *MemoryUpdateFuture {
+	f := &MemoryUpdateFuture{
+		done:   make(chan struct{}),
+		update: &model.MemoryUpdate{UserID: userID, SessionID: sessionID},
+	}
+	go func() {
+		defer close(f.done)
+		var errs []error
+		for _, ch := range results {
+			result := <-ch
+			f.update.AddEvents(result.Events)
+			errs = append(errs, result.Err)
+		}
+		f.update.Err = errors.Join(errs...)
+	}()
+	return f
+}
+
+// 更新完成时关闭
+func (f *MemoryUpdateFuture) Done() <-chan struct{} {
+	return f.done
+}
+
+// 等待更新完成并返回结果
+func (f *MemoryUpdateFuture) Wait() *model.MemoryUpdate {
+	<-f.done
+	return f.update
+}
+
+// 注册长期记忆更新的回调 每次抽取任务完成(或超过最大尝试次数)以及 FlushMemory 后调用
+// 关闭时 有等待者的未完成抽取任务以 ErrJobQueueStopped 调用一次
+// 回调在后台任务的goroutine中执行 不应长时间阻塞
+func (m *MemorySystem) OnMemoryUpdated(fn func(update *model.MemoryUpdate)) {
+	m.callbackMu.Lock()
+	defer m.callbackMu.Unlock()
+	m.onMemoryUpdated = fn
+}
+
+func (m *MemorySystem) handleJobResult(result JobResult) {
+	if result.Job.Type != model.JobTypeExtract {
+		return
+	}
+	update := &model.MemoryUpdate{
+		UserID:    result.Job.UserID,
+		SessionID: result.Job.SessionID,
+		Err:       result.Err,
+	}
+	update.AddEvents(result.Events)
+	m.notifyMemoryUpdated(update)
+}
+
+func (m *MemorySystem) notifyMemoryUpdated(update *model.MemoryUpdate) {
+	m.callbackMu.RLock()
+	fn := m.onMemoryUpdated
+	m.callbackMu.RUnlock()
+	if fn != nil {
+		fn(update)
+	}
+}
@@ -0,0 +1,183 @@
+package memory
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/xuanlv2002/miniMem0/config"
+	"github.com/xuanlv2002/miniMem0/llm/llmtest"
+	"github.com/xuanlv2002/miniMem0/model"
+)
+
+// 记录 OnMemoryUpdated 收到的更新
+type updateRecorder struct {
+	mu      sync.Mutex
+	updates []*model.MemoryUpdate
+}
+
+func (r *updateRecorder) record(update *model.MemoryUpdate) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.updates = append(r.updates, update)
+}
+
+func (r *updateRecorder) get() []*model.MemoryUpdate {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*model.MemoryUpdate(nil), r.updates...)
+}
+
+// 更新中实际执行的操作的内容
+func eventTexts(update *model.MemoryUpdate) []string {
+	var texts []string
+	for _, event := range update.Events {
+		texts = append(texts, event.Event+" "+event.Text)
+	}
+	return texts
+}
+
+// 等待 future 完成
+func waitUpdate(t *testing.T, f *MemoryUpdateFuture) *model.MemoryUpdate {
+	t.Helper()
+	select {
+	case <-f.Done():
+		return f.Wait()
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for the memory update")
+		return nil
+	}
+}
+
+func TestMemoryUpdateFutureMergedJob(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	chat := llmtest.NewScriptedChatModel()
+	chat.Handler = func(messages []openai.ChatCompletionMessage) llmtest.Response {
+		input := messages[len(messages)-1].Content
+		switch {
+		case strings.Contains(input, "#可能相关的记忆"):
+			text := "我叫小明"
+			if strings.Contains(input, "喜欢游泳") {
+				text = "喜欢游泳"
+			}
+			return llmtest.Response{Content: memoryResponse(t, model.MemoryEvent{Text: text, Event: "ADD"})}
+		case strings.Contains(input, "#待提取信息记忆"):
+			if strings.Contains(input, "我喜欢游泳") {
+				return llmtest.Response{Content: factsResponse(t, model.Fact{Content: "喜欢游泳", About: "user"})}
+			}
+			// 第一次抽取执行到这里时暂停 之后的输出会合并到新的抽取任务中
+			close(started)
+			<-release
+			return llmtest.Response{Content: factsResponse(t, model.Fact{Content: "我叫小明", About: "user"})}
+		default:
+			return llmtest.Response{Content: "用户的对话"}
+		}
+	}
+	m := newTestMemorySystem(t, chat)
+	var recorder updateRecorder
+	m.OnMemoryUpdated(recorder.record)
+
+	if _, err := m.ProcessInput(testUser, testSession, "我叫小明"); err != nil {
+		t.Fatalf("ProcessInput: %v", err)
+	}
+	first, err := m.ProcessOutputAsync(testUser, testSession, "你好小明")
+	if err != nil {
+		t.Fatalf("ProcessOutputAsync: %v", err)
+	}
+	select {
+	case <-started:
+	case <-time.After(10 * time.Second):
+		t.Fatal("extraction not started")
+	}
+
+	// 第一次抽取执行中 之后两次输出的抽取合并为同一个任务
+	if _, err := m.ProcessInput(testUser, testSession, "我喜欢游泳"); err != nil {
+		t.Fatalf("ProcessInput: %v", err)
+	}
+	second, err := m.ProcessOutputAsync(testUser, testSession, "游泳很好")
+	if err != nil {
+		t.Fatalf("ProcessOutputAsync: %v", err)
+	}
+	third, err := m.ProcessOutputAsync(testUser, testSession, "还有什么想聊的")
+	if err != nil {
+		t.Fatalf("ProcessOutputAsync: %v", err)
+	}
+	close(release)
+
+	if got := eventTexts(waitUpdate(t, first)); len(got) != 1 || got[0] != "ADD 我叫小明" {
+		t.Fatalf("first update events = %v, want ADD 我叫小明", got)
+	}
+	for _, f := range []*MemoryUpdateFuture{second, third} {
+		update := waitUpdate(t, f)
+		if update.Err != nil {
+			t.Fatalf("merged update error: %v", update.Err)
+		}
+		if got := eventTexts(update); len(got) != 1 || got[0] != "ADD 喜欢游泳" {
+			t.Fatalf("merged update events = %v, want ADD 喜欢游泳", got)
+		}
+	}
+
+	// 每个抽取任务的结果只回调一次
+	m.WaitDone()
+	updates := recorder.get()
+	if len(updates) != 2 {
+		t.Fatalf("got %d callbacks, want one per extraction job", len(updates))
+	}
+	for i, want := range []string{"ADD 我叫小明", "ADD 喜欢游泳"} {
+		if got := eventTexts(updates[i]); len(got) != 1 || got[0] != want {
+			t.Fatalf("callback %d events = %v, want %s", i, got, want)
+		}
+	}
+}
+
+func TestMemoryUpdateStopped(t *testing.T) {
+	chat := llmtest.NewScriptedChatModel()
+	chat.Handler = func(messages []openai.ChatCompletionMessage) llmtest.Response {
+		input := messages[len(messages)-1].Content
+		if strings.Contains(input, "#待总结对话") {
+			return llmtest.Response{Content: "用户的对话"}
+		}
+		return llmtest.Response{Err: errors.New("model unavailable")}
+	}
+	m := newTestMemorySystem(t, chat, func(cfg *config.Config) {
+		cfg.JobConfig = &config.JobConfig{RetryDelay: 60}
+	})
+	var recorder updateRecorder
+	m.OnMemoryUpdated(recorder.record)
+
+	if _, err := m.ProcessInput(testUser, testSession, "我叫小明"); err != nil {
+		t.Fatalf("ProcessInput: %v", err)
+	}
+	f, err := m.ProcessOutputAsync(testUser, testSession, "你好小明")
+	if err != nil {
+		t.Fatalf("ProcessOutputAsync: %v", err)
+	}
+	// 抽取失败后等待退避重试 此时关闭 等待者和回调收到队列停止的结果
+	waitFor(t, "failed extraction", func() bool {
+		jobs, err := m.ListJobs(testUser, model.JobStatusPending)
+		if err != nil {
+			t.Fatalf("ListJobs: %v", err)
+		}
+		return len(jobs) == 1 && jobs[0].Type == model.JobTypeExtract && jobs[0].Attempts == 1
+	})
+	select {
+	case <-f.Done():
+		t.Fatal("update finished before the extraction")
+	default:
+	}
+	m.Close()
+
+	update := waitUpdate(t, f)
+	if !errors.Is(update.Err, ErrJobQueueStopped) || len(update.Events) != 0 {
+		t.Fatalf("got update %+v, want ErrJobQueueStopped", update)
+	}
+	m.Close()
+	updates := recorder.get()
+	if len(updates) != 1 || !errors.Is(updates[0].Err, ErrJobQueueStopped) {
+		t.Fatalf("got callbacks %+v, want one with ErrJobQueueStopped", updates)
+	}
+}
@@ -0,0 +1,50 @@
+package model
+
+import (
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/xuanlv2002/miniMem0/prompt"
+)
+
+// 结构化的记忆上下文 由 MemorySystem.ProcessInputStructured 返回
+type MemoryContext struct {
+	Summary       string                         `json:"summary"`             // 上下文摘要
+	LongMemories  []LongMemoryItem               `json:"long_memories"`       // 相关的长期记忆 含ID、相关度和元数据
+	Relations     []RelationItem                 `json:"relations,omitempty"` // 关系记忆 未开启时为空
+	ShortMemories []openai.ChatCompletionMessage `json:"short_memories"`      // 最近的对话 按时间从早到晚
+	Input         string                         `json:"input"`               // 用户本次输入
+	Usage         *TokenUsage                    `json:"usage"`               // token用量
+	Queries       []string                       `json:"queries,omitempty"`   // 检索长期记忆使用的语句 开启查询改写时为改写后的语句
+	Templates     *prompt.Templates              `json:"-"`                   // 渲染使用的模板 为空时使用内置中文模板
+}
+
+// 转为可以直接发送给大模型的消息列表
+// 摘要和长期记忆追加在系统提示词之后 短期记忆作为历史对话 最后是用户本次输入
+func (c *MemoryContext) ToMessages(systemPrompt string) []openai.ChatCompletionMessage {
+	var system strings.Builder
+	if systemPrompt != "" {
+		system.WriteString(systemPrompt)
+		system.WriteString("\n\n")
+	}
+	contextMemory := ContextMemory{Summary: c.Summary}
+	longMemory := LongMemory{VectorMemorys: c.LongMemories, Relations: c.Relations}
+	templates := c.Templates
+	if templates == nil {
+		templates = prompt.Default()
+	}
+	system.WriteString(contextMemory.GetPromptWith(templates))
+	system.WriteString(longMemory.GetPromptWith(templates))
+
+	messages := make([]openai.ChatCompletionMessage, 0, len(c.ShortMemories)+2)
+	messages = append(messages, openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleSystem,
+		Content: system.String(),
+	})
+	messages = append(messages, c.ShortMemories...)
+	messages = append(messages, openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleUser,
+		Content: c.Input,
+	})
+	return messages
+}
@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+// 向量缓存 以(向量模型, 维度, 文本sha256)为键 避免重复向量化
+type EmbeddingCache struct {
+	Model      string    `gorm:"primaryKey"`
+	Dimensions int       `gorm:"primaryKey"`
+	Hash       string    `gorm:"primaryKey"` // 文本的sha256
+	Vector     []byte    // 小端序 float32 编码的向量
+	LastUsedAt time.Time `gorm:"index"` // 最近使用时间 用于LRU淘汰
+	CreatedAt  time.Time // 内置默认时间
+}
@@ -0,0 +1,50 @@
+package model
+
+import "time"
+
+// 关系记忆中的实体 人物 地点 事物等 同一用户下按名称唯一
+type Entity struct {
+	ID        int64     `gorm:"primaryKey" json:"id"`
+	UserID    string    `gorm:"uniqueIndex:idx_entity_user_name" json:"user_id"` // 所属用户
+	Name      string    `gorm:"uniqueIndex:idx_entity_user_name" json:"name"`    // 实体名称 用户本人为 user
+	Type      string    `json:"type,omitempty"`                                  // 实体类型 如 person/place/thing
+	CreatedAt time.Time `json:"created_at"`                                      // 内置默认时间
+	UpdatedAt time.Time `json:"updated_at"`                                      // 最近修改时间
+}
+
+// 实体之间有类型的关系 如 user -朋友-> 约翰
+type Relation struct {
+	ID              int64      `gorm:"primaryKey" json:"id"`
+	UserID          string     `gorm:"index" json:"user_id"`   // 所属用户
+	SourceID        int64      `gorm:"index" json:"source_id"` // 起点实体
+	TargetID        int64      `gorm:"index" json:"target_id"` // 终点实体
+	Type            string     `json:"type"`                   // 关系类型 如 朋友 喜欢 住在
+	Source          *Entity    `gorm:"foreignKey:SourceID" json:"source,omitempty"`
+	Target          *Entity    `gorm:"foreignKey:TargetID" json:"target,omitempty"`
+	ExtractionRunID int64      `gorm:"index" json:"extraction_run_id,omitempty"` // 产生关系的抽取批次
+	DeletedRunID    int64      `gorm:"index" json:"-"`                           // 删除关系的抽取批次 撤销抽取时恢复
+	DeletedAt       *time.Time `gorm:"index" json:"-"`                           // 被新事实推翻的时间 未删除时为空
+	CreatedAt       time.Time  `json:"created_at"`                               // 内置默认时间
+	UpdatedAt       time.Time  `json:"updated_at"`                               // 最近一次被抽取到的时间
+}
+
+// 渲染到提示词中的一条关系
+type RelationItem struct {
+	Source     string `json:"source"`
+	SourceType string `json:"source_type,omitempty"`
+	Relation   string `json:"relation"`
+	Target     string `json:"target"`
+	TargetType string `json:"target_type,omitempty"`
+}
+
+// 转为渲染使用的关系 实体未加载时名称为空
+func (r *Relation) Item() RelationItem {
+	item := RelationItem{Relation: r.Type}
+	if r.Source != nil {
+		item.Source, item.SourceType = r.Source.Name, r.Source.Type
+	}
+	if r.Target != nil {
+		item.Target, item.TargetType = r.Target.Name, r.Target.Type
+	}
+	return item
+}
@@ -0,0 +1,43 @@
+package model
+
+import "time"
+
+// 长期记忆的变更记录 每次 ADD/UPDATE/DELETE 记录一条
+type MemoryHistory struct {
+	ID              int64             `gorm:"primaryKey" json:"id"`
+	MemoryID        string            `gorm:"index" json:"memory_id"`
+	UserID          string            `gorm:"index" json:"user_id"`                      // 所属用户
+	SessionID       string            `json:"session_id,omitempty"`                      // 触发变更的会话 手动操作时为空
+	Version         int               `json:"version"`                                   // 记忆的版本 每次变更加1 ADD时为1
+	Event           string            `json:"event"`                                     // ADD/UPDATE/DELETE
+	OldText         string            `json:"old_text,omitempty"`                        // 变更前的内容 ADD时为空
+	NewText         string            `json:"new_text,omitempty"`                        // 变更后的内容 DELETE时为空
+	OldMeta         map[string]string `gorm:"serializer:json" json:"old_meta,omitempty"` // 变更前的元数据
+	NewMeta         map[string]string `gorm:"serializer:json" json:"new_meta,omitempty"` // 变更后的元数据
+	SourceStartID   int64             `json:"source_start_id,omitempty"`                 // 来源对话 OriginalMemory 的ID范围 手动操作时为0
+	SourceEndID     int64             `json:"source_end_id,omitempty"`
+	ExtractionRunID int64             `gorm:"index" json:"extraction_run_id,omitempty"` // 产生变更的抽取批次 手动操作时为0
+	Reason          string            `json:"reason,omitempty"`                         // 手动操作的说明 如回滚
+	CreatedAt       time.Time         `json:"created_at"`                               // 内置默认时间
+}
+
+// 一次长期记忆抽取 即一次 SaveLongMemory 的执行 用于整批撤销
+// 模型返回的操作先保存为写入计划 写入中途失败时 重试按计划继续 跳过已经执行的操作
+type ExtractionRun struct {
+	ID               int64      `gorm:"primaryKey" json:"id"`
+	UserID           string     `gorm:"index" json:"user_id"`    // 所属用户
+	SessionID        string     `gorm:"index" json:"session_id"` // 所属会话
+	PrevExtractionID int64      `json:"prev_extraction_id"`      // 抽取前会话的 LastExtractionID 撤销时可以退回到这里
+	SourceStartID    int64      `json:"source_start_id"`         // 本次抽取的 OriginalMemory 的ID范围
+	SourceEndID      int64      `json:"source_end_id"`
+	Plan             string     `json:"-"`                     // 写入计划(JSON) 包含记忆操作和关系变更
+	GraphApplied     bool       `json:"-"`                     // 关系变更是否已写入
+	FinishedAt       *time.Time `json:"finished_at,omitempty"` // 写入完成的时间 与抽取进度同时更新 未完成时为空
+	RevertedAt       *time.Time `json:"reverted_at,omitempty"` // 撤销时间 未撤销时为空
+	CreatedAt        time.Time  `json:"created_at"`            // 内置默认时间
+}
+
+// 是否有写入计划但还没有写入完成 没有写入计划的旧记录视为已完成
+func (r *ExtractionRun) Unfinished() bool {
+	return r.Plan != "" && r.FinishedAt == nil
+}
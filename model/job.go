@@ -0,0 +1,30 @@
+package model
+
+import "time"
+
+// 后台记忆任务类型
+const (
+	JobTypeSummarize = "summarize" // 总结上下文摘要记忆
+	JobTypeExtract   = "extract"   // 抽取长期记忆
+)
+
+// 后台记忆任务状态 任务成功后直接删除
+const (
+	JobStatusPending = "pending" // 等待执行 或等待失败后的重试
+	JobStatusRunning = "running" // 执行中
+	JobStatusFailed  = "failed"  // 超过最大尝试次数 需要手动重试
+)
+
+// 持久化的后台记忆任务 程序崩溃重启后会继续执行
+type MemoryJob struct {
+	ID        int64     `gorm:"primaryKey" json:"id"`
+	Type      string    `gorm:"index:idx_memory_job_key" json:"type"`
+	UserID    string    `gorm:"index:idx_memory_job_key" json:"user_id"`    // 所属用户
+	SessionID string    `gorm:"index:idx_memory_job_key" json:"session_id"` // 所属会话
+	Status    string    `gorm:"index" json:"status"`
+	Attempts  int       `json:"attempts"`                 // 已尝试次数
+	LastError string    `json:"last_error,omitempty"`     // 最近一次失败的错误
+	NextRunAt time.Time `gorm:"index" json:"next_run_at"` // 最早可执行时间 失败后按指数退避推迟
+	CreatedAt time.Time `json:"created_at"`               // 内置默认时间
+	UpdatedAt time.Time `json:"updated_at"`               // 最近修改时间
+}
@@ -3,13 +3,17 @@ package model
 import (
 	"fmt"
 	"time"
+
+	"github.com/xuanlv2002/miniMem0/prompt"
 )
 
 type MemorySource string
 
 // 原始记忆信息
 type OriginalMemory struct {
-	ID        int64 `gorm:"primaryKey"`
+	ID        int64  `gorm:"primaryKey"`
+	UserID    string `gorm:"index"` // 所属用户
+	SessionID string `gorm:"index"` // 所属会话
 	Role      MemorySource
 	Content   string
 	CreatedAt time.Time // 内置默认时间
@@ -19,9 +23,25 @@ func (o *OriginalMemory) GetPrompt() string {
 	return fmt.Sprintf("%v - %v:%v", o.CreatedAt.Format("2006-01-02 15:04:05"), o.Role, o.Content)
 }
 
-// 记忆上下文结构体
+// 作为用户输入渲染
+func (o *OriginalMemory) GetInputPromptWith(t *prompt.Templates) string {
+	return t.Render(prompt.UserInputTemplate, o.Turn())
+}
+
+// 转为模板中的一轮对话
+func (o *OriginalMemory) Turn() prompt.Turn {
+	return prompt.Turn{
+		Time:    o.CreatedAt.Format("2006-01-02 15:04:05"),
+		Role:    string(o.Role),
+		Content: o.Content,
+	}
+}
+
+// 记忆上下文结构体 每个会话一份
 type ContextMemory struct {
 	ID            int64
+	UserID        string    `gorm:"index"` // 所属用户
+	SessionID     string    `gorm:"index"` // 所属会话
 	Summary       string    // 用于管理记忆上下文，及智能体所处的环境,总结,对内容理解提供一个大致的方向性
 	LastSummaryID int64     // 最后一次总结的id
 	UpdatedAt     time.Time // 最近修改时间
@@ -29,10 +49,12 @@ type ContextMemory struct {
 }
 
 func (c *ContextMemory) GetPrompt() string {
-	if c.Summary == "" {
-		return "#上下文摘要记忆: \n 暂无摘要信息\n"
-	}
-	return "#上下文摘要记忆: \n" + c.Summary + "\n"
+	return c.GetPromptWith(prompt.Default())
+}
+
+// 使用指定的模板渲染
+func (c *ContextMemory) GetPromptWith(t *prompt.Templates) string {
+	return t.Render(prompt.ContextMemoryTemplate, prompt.ContextData{Summary: c.Summary})
 }
 
 // 短期记忆结构体
@@ -41,53 +63,148 @@ type ShortMemory struct {
 }
 
 func (s *ShortMemory) GetPrompt() string {
-	content := "#短期记忆: \n"
-	if len(s.Memorys) == 0 {
-		return content + "暂无短期记忆信息"
-	}
+	return s.GetPromptWith(prompt.Default())
+}
+
+// 使用指定的模板渲染
+func (s *ShortMemory) GetPromptWith(t *prompt.Templates) string {
+	data := prompt.ShortData{Turns: make([]prompt.Turn, 0, len(s.Memorys))}
 	for _, memory := range s.Memorys {
-		content += fmt.Sprintf("%v - %v:%v", memory.CreatedAt.Format("2006-01-02 15:04:05"), memory.Role, memory.Content)
+		data.Turns = append(data.Turns, memory.Turn())
 	}
-	return content
+	return t.Render(prompt.ShortMemoryTemplate, data)
 }
 
 type LongMemoryItem struct {
-	ID       string            `json:"id"`
-	Text     string            `json:"text"`
-	Meta     map[string]string `json:"meta"`
-	Similary float32           `json:"similary,omitempty"` // 基于文本内容符合度搜索   基于元数据搜索
+	ID          string            `json:"id"`
+	Text        string            `json:"text"`
+	Meta        map[string]string `json:"meta"`
+	Similary    float32           `json:"similary,omitempty"`     // 基于文本内容符合度搜索   基于元数据搜索
+	Score       float32           `json:"score,omitempty"`        // 排序使用的综合得分 未开启时间衰减时等于相似度 开启关键词检索时为融合得分
+	RerankScore float32           `json:"rerank_score,omitempty"` // 重排序得分 0~1 未开启重排序时为0
 }
 
 // 长期记忆结构体
+// 长期记忆事实按用户隔离 抽取进度按会话记录(抽取时会参考会话的上下文摘要)
 type LongMemory struct {
 	ID               int64
+	UserID           string           `gorm:"index"` // 所属用户
+	SessionID        string           `gorm:"index"` // 抽取进度所属会话
 	LastExtractionID int64            // 最近一次抽取长期记忆ID
 	VectorMemorys    []LongMemoryItem `gorm:"-"` // 基于语义相似搜索
-	// 基于模型来把自然语言转为结构化查询 来获得更全面的关系数据 暂未实现
-	// 通过混合长期记忆搜索的方式 获得更全面的消息信息(function call?)
-	UpdatedAt time.Time // 最近修改时间
+	Queries          []string         `gorm:"-"` // 检索使用的语句 开启查询改写时为改写后的语句
+	Relations        []RelationItem   `gorm:"-"` // 关系记忆 输入中提到的实体及其相连的关系
+	UpdatedAt        time.Time        // 最近修改时间
 }
 
 func (l *LongMemory) GetPrompt() string {
-	if len(l.VectorMemorys) == 0 {
-		return "#长期记忆: \n 暂无长期记忆信息"
-	}
-	content := "#长期记忆: \n"
+	return l.GetPromptWith(prompt.Default())
+}
+
+// 使用指定的模板渲染
+func (l *LongMemory) GetPromptWith(t *prompt.Templates) string {
+	data := prompt.LongData{Items: make([]prompt.LongItem, 0, len(l.VectorMemorys))}
 	for _, item := range l.VectorMemorys {
-		content += fmt.Sprintf("记忆内容:%v \n记忆元信息:%v \n记忆相关度:%v \n\n", item.Text, item.Meta, item.Similary)
+		data.Items = append(data.Items, prompt.LongItem{
+			ID:         item.ID,
+			Text:       item.Text,
+			Meta:       PromptMeta(item.Meta),
+			Similarity: item.Similary,
+		})
 	}
-	return content
+	graph := prompt.GraphData{Relations: make([]prompt.GraphRelation, 0, len(l.Relations))}
+	for _, item := range l.Relations {
+		graph.Relations = append(graph.Relations, prompt.GraphRelation{
+			Source:   item.Source,
+			Relation: item.Relation,
+			Target:   item.Target,
+		})
+	}
+	return t.Render(prompt.LongMemoryTemplate, data) + t.Render(prompt.GraphMemoryTemplate, graph)
 }
 
 type MemoryEvent struct {
-	ID    string            `json:"id"`
-	Text  string            `json:"text"`
-	Meta  map[string]string `json:"meta"`
-	Event string            `json:"event"`
+	ID         string            `json:"id"`
+	Text       string            `json:"text"`
+	Meta       map[string]string `json:"meta"`
+	Event      string            `json:"event"`
+	Importance int               `json:"importance,omitempty"` // 对应事实的重要性 由程序写入 不由大模型输出
+	OldMemory  string            `json:"old_memory,omitempty"` // UPDATE/DELETE 前的记忆内容
+	Reason     string            `json:"reason,omitempty"`     // 操作被拒绝的原因 为空时表示已执行
+}
+
+// 一次后台记忆更新的结果
+type MemoryUpdate struct {
+	UserID    string        `json:"user_id"`
+	SessionID string        `json:"session_id"`
+	Events    []MemoryEvent `json:"events"`             // 长期记忆中实际执行的 ADD/UPDATE/DELETE 操作 ADD 的 ID 为新记忆的ID
+	Rejected  []MemoryEvent `json:"rejected,omitempty"` // 模型返回但被拒绝执行的操作 如引用了不存在的记忆ID
+	Err       error         `json:"-"`                  // 更新失败(超过最大尝试次数或队列已停止)时的错误
+}
+
+// 按是否被拒绝把记忆操作加入 Events 或 Rejected
+func (u *MemoryUpdate) AddEvents(events []MemoryEvent) {
+	for _, event := range events {
+		if event.Reason != "" {
+			u.Rejected = append(u.Rejected, event)
+		} else {
+			u.Events = append(u.Events, event)
+		}
+	}
 }
 
 type Fact struct {
 	Content    string `json:"content"`
 	AppearTime string `json:"appearTime"`
 	About      string `json:"about"`
+	Importance int    `json:"importance"` // 重要性 1~10 写入记忆元数据 用于容量满时淘汰记忆
+}
+
+// 长期记忆元数据中由系统维护的字段 大模型返回的元数据不能覆盖这些字段
+const (
+	MetaUserID           = "user_id"            // 记忆所属用户
+	MetaCreatedAt        = "created_at"         // 创建时间 RFC3339
+	MetaUpdatedAt        = "updated_at"         // 最近修改时间 RFC3339
+	MetaSourceMessageIDs = "source_message_ids" // 产生记忆的对话 OriginalMemory 的ID 逗号分隔 UPDATE 时合并
+	MetaExtractionRun    = "extraction_run"     // 最近一次修改记忆的抽取批次
+	MetaEmbeddingModel   = "embedding_model"    // 生成向量的模型
+	MetaVersion          = "version"            // 记忆的版本 与变更记录一致
+	MetaImportance       = "importance"         // 记忆的重要性 1~10 由对应事实的重要性写入 缺失时按 DefaultImportance 计算
+)
+
+// 大模型抽取的事实出现时间 格式为 2006-01-02 15:04:05
+const MetaAppearTime = "appearTime"
+
+// 没有重要性的记忆按中等重要计算
+const DefaultImportance = 5
+
+// 是否是系统维护的元数据字段
+func IsSystemMeta(key string) bool {
+	switch key {
+	case MetaUserID, MetaCreatedAt, MetaUpdatedAt, MetaSourceMessageIDs, MetaExtractionRun, MetaEmbeddingModel, MetaVersion, MetaImportance:
+		return true
+	}
+	return false
+}
+
+// 去掉系统维护的字段 只保留大模型抽取的元数据 用于渲染提示词
+func PromptMeta(metadata map[string]string) map[string]string {
+	ret := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		if !IsSystemMeta(k) {
+			ret[k] = v
+		}
+	}
+	return ret
+}
+
+// 提示词各部分的token用量
+type TokenUsage struct {
+	Summary   int  `json:"summary"`   // 上下文摘要记忆
+	Long      int  `json:"long"`      // 长期记忆
+	Short     int  `json:"short"`     // 短期记忆
+	Input     int  `json:"input"`     // 用户输入
+	Total     int  `json:"total"`     // 合计
+	Budget    int  `json:"budget"`    // 总预算 0表示不限制
+	Truncated bool `json:"truncated"` // 是否因预算裁剪过记忆
 }
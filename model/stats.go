@@ -0,0 +1,11 @@
+package model
+
+import "time"
+
+// 长期记忆的使用情况 记忆每次被检索返回时更新 用于容量满时计算记忆的价值
+type MemoryStat struct {
+	MemoryID       string     `gorm:"primaryKey" json:"memory_id"`
+	UserID         string     `gorm:"index" json:"user_id"`       // 所属用户
+	AccessCount    int64      `json:"access_count"`               // 被检索返回的次数
+	LastAccessedAt *time.Time `json:"last_accessed_at,omitempty"` // 最近一次被检索返回的时间
+}
@@ -0,0 +1,29 @@
+package prompt
+
+import "unicode"
+
+// 基于文字的简单语言检测
+// 出现假名或谚文时不是中文 使用英文模板(英文模板要求按输入的语言记录事实)
+// 否则汉字较多时使用中文模板 一个汉字大约对应一个英文单词 按4个字母折算
+func DetectLanguage(texts ...string) string {
+	var han, latin int
+	for _, text := range texts {
+		for _, r := range text {
+			switch {
+			case unicode.Is(unicode.Hiragana, r), unicode.Is(unicode.Katakana, r), unicode.Is(unicode.Hangul, r):
+				return LanguageEn
+			case unicode.Is(unicode.Han, r):
+				han++
+			case unicode.Is(unicode.Latin, r):
+				latin++
+			}
+		}
+	}
+	if han == 0 && latin == 0 {
+		return LanguageZh
+	}
+	if han*4 >= latin {
+		return LanguageZh
+	}
+	return LanguageEn
+}
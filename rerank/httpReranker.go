@@ -0,0 +1,91 @@
+package rerank
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/xuanlv2002/miniMem0/config"
+)
+
+// 调用 Jina Cohere 等兼容的 /rerank 接口 通常由交叉编码器模型提供
+// 请求 {"model", "query", "documents", "top_n"} 响应 {"results": [{"index", "relevance_score"}]}
+type HTTPReranker struct {
+	config *config.RerankConfig
+	client *http.Client
+}
+
+var _ Reranker = (*HTTPReranker)(nil)
+
+func NewHTTPReranker(cfg *config.RerankConfig) (*HTTPReranker, error) {
+	if cfg.BaseURL == "" {
+		return nil, errors.New("rerank BASE_URL is required for http provider")
+	}
+	return &HTTPReranker{config: cfg, client: &http.Client{}}, nil
+}
+
+type httpRerankRequest struct {
+	Model     string   `json:"model,omitempty"`
+	Query     string   `json:"query"`
+	Documents []string `json:"documents"`
+	TopN      int      `json:"top_n,omitempty"`
+}
+
+type httpRerankResponse struct {
+	Results []struct {
+		Index          int     `json:"index"`
+		RelevanceScore float32 `json:"relevance_score"`
+	} `json:"results"`
+}
+
+func (r *HTTPReranker) Rerank(ctx context.Context, query string, documents []string) ([]Result, error) {
+	if len(documents) == 0 {
+		return nil, nil
+	}
+	body, err := json.Marshal(httpRerankRequest{
+		Model:     r.config.Model,
+		Query:     query,
+		Documents: documents,
+		TopN:      len(documents),
+	})
+	if err != nil {
+		return nil, err
+	}
+	url := strings.TrimSuffix(r.config.BaseURL, "/") + "/rerank"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.config.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+r.config.APIKey)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("rerank request failed: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var response httpRerankResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("decode rerank response: %v", err)
+	}
+	results := make([]Result, 0, len(response.Results))
+	for _, v := range response.Results {
+		if v.Index < 0 || v.Index >= len(documents) {
+			continue
+		}
+		results = append(results, Result{Index: v.Index, Score: v.RelevanceScore})
+	}
+	sortResults(results)
+	return results, nil
+}
@@ -0,0 +1,114 @@
+package rerank
+
+import (
+	"context"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/sashabaranov/go-openai/jsonschema"
+	"github.com/xuanlv2002/miniMem0/llm"
+	"github.com/xuanlv2002/miniMem0/prompt"
+)
+
+// 对话模型给出的最高分
+const llmMaxScore = 10
+
+// 重排序的输出 {"scores": [{"id", "score"}]}
+var scoresSchema = &llm.OutputSchema{
+	Name:        "rerank_memories",
+	Description: "候选记忆与用户输入的相关度",
+	Strict:      true,
+	Schema: jsonschema.Definition{
+		Type:                 jsonschema.Object,
+		Required:             []string{"scores"},
+		AdditionalProperties: false,
+		Properties: map[string]jsonschema.Definition{
+			"scores": {
+				Type: jsonschema.Array,
+				Items: &jsonschema.Definition{
+					Type:                 jsonschema.Object,
+					Required:             []string{"id", "score"},
+					AdditionalProperties: false,
+					Properties: map[string]jsonschema.Definition{
+						"id":    {Type: jsonschema.Integer, Description: "候选记忆编号"},
+						"score": {Type: jsonschema.Number, Description: "相关度 0到10"},
+					},
+				},
+			},
+		},
+	},
+}
+
+// 使用对话模型为候选打分 一次请求评估所有候选
+type LLMReranker struct {
+	chatModel llm.ChatModel
+	templates *prompt.Templates
+}
+
+var _ Reranker = (*LLMReranker)(nil)
+
+func NewLLMReranker(chatModel llm.ChatModel, templates *prompt.Templates) *LLMReranker {
+	if templates == nil {
+		templates = prompt.Default()
+	}
+	return &LLMReranker{chatModel: chatModel, templates: templates}
+}
+
+func (r *LLMReranker) Rerank(ctx context.Context, query string, documents []string) ([]Result, error) {
+	if len(documents) == 0 {
+		return nil, nil
+	}
+	templates := r.templates.Detect(query)
+	data := prompt.RerankInputData{Query: query}
+	for i, doc := range documents {
+		data.Documents = append(data.Documents, prompt.RerankDocument{ID: i + 1, Text: doc})
+	}
+	messages := []openai.ChatCompletionMessage{
+		{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: templates.System(prompt.RerankTemplate),
+		},
+		{
+			Role:    openai.ChatMessageRoleUser,
+			Content: templates.Render(prompt.RerankInputTemplate, data),
+		},
+	}
+
+	var content string
+	if structured, ok := r.chatModel.(llm.StructuredChatModel); ok {
+		out, err := structured.ChatStructured(ctx, messages, scoresSchema)
+		if err != nil {
+			return nil, err
+		}
+		content = out
+	} else {
+		msg, err := r.chatModel.Chat(ctx, messages)
+		if err != nil {
+			return nil, err
+		}
+		content = msg.Content
+	}
+	var response struct {
+		Scores []struct {
+			ID    int     `json:"id"`
+			Score float32 `json:"score"`
+		} `json:"scores"`
+	}
+	if err := llm.ParseJSON(content, &response); err != nil {
+		return nil, err
+	}
+
+	// 忽略不存在的编号 同一编号以第一次为准
+	seen := make(map[int]bool, len(response.Scores))
+	results := make([]Result, 0, len(response.Scores))
+	for _, s := range response.Scores {
+		index := s.ID - 1
+		if index < 0 || index >= len(documents) || seen[index] {
+			continue
+		}
+		seen[index] = true
+		score := min(max(s.Score, 0), llmMaxScore) / llmMaxScore
+		results = append(results, Result{Index: index, Score: score})
+	}
+	sortResults(results)
+	return results, nil
+}
@@ -0,0 +1,62 @@
+package rerank
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/xuanlv2002/miniMem0/config"
+	"github.com/xuanlv2002/miniMem0/llm"
+	"github.com/xuanlv2002/miniMem0/prompt"
+)
+
+/*
+	长期记忆检索结果的重排序
+	向量和关键词检索只按相似度召回 候选中常有与当前输入关系不大的记忆
+	重排序对候选逐条评估与查询的相关度 排序并裁剪后再渲染到提示词中
+	可以通过实现 Reranker 接入其他重排序模型
+*/
+
+const (
+	ProviderLLM  = "llm"  // 使用对话模型打分
+	ProviderHTTP = "http" // 调用 /rerank 接口
+)
+
+// 一条候选的重排序结果
+type Result struct {
+	Index int     // 候选在输入中的下标
+	Score float32 // 相关度得分 0~1
+}
+
+type Reranker interface {
+	// 评估每条候选与查询的相关度 返回按得分从高到低排序的结果
+	// 没有返回的候选视为不相关
+	Rerank(ctx context.Context, query string, documents []string) ([]Result, error)
+}
+
+// 根据配置创建重排序器 未配置时返回nil
+func New(cfg *config.RerankConfig, chatModel llm.ChatModel, templates *prompt.Templates) (Reranker, error) {
+	switch cfg.Provider {
+	case "":
+		return nil, nil
+	case ProviderLLM:
+		return NewLLMReranker(chatModel, templates), nil
+	case ProviderHTTP:
+		return NewHTTPReranker(cfg)
+	default:
+		return nil, fmt.Errorf("unsupported rerank provider: %s", cfg.Provider)
+	}
+}
+
+// 按得分从高到低排序 得分相同时保持原顺序
+func sortResults(results []Result) {
+	slices.SortStableFunc(results, func(a, b Result) int {
+		switch {
+		case a.Score > b.Score:
+			return -1
+		case a.Score < b.Score:
+			return 1
+		}
+		return a.Index - b.Index
+	})
+}
@@ -0,0 +1,415 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xuanlv2002/miniMem0/memory"
+	"gorm.io/gorm"
+)
+
+/*
+	将记忆系统以 RESTful 接口的形式对外提供服务
+	POST   /v1/input     处理用户输入 返回带有记忆的提示词
+	POST   /v1/output    记录大模型的回复
+	GET    /v1/memories  查询用户的长期记忆 带 query 参数时按相关度搜索 可按元数据和内容过滤
+	DELETE /v1/memories  删除用户的长期记忆
+	GET    /v1/memories/archived      查询因超出容量被归档的长期记忆
+	GET    /v1/memories/{id}/history  查询长期记忆的变更记录
+	POST   /v1/memories/{id}/rollback 把长期记忆回滚到指定版本
+	GET    /v1/extractions            查询长期记忆的抽取批次
+	POST   /v1/extractions/{id}/revert 撤销一次抽取的所有变更
+	GET    /v1/relations  查询用户的关系记忆
+	POST   /v1/flush     立即更新会话记忆
+	GET    /v1/jobs      查询用户的后台记忆任务
+	POST   /v1/jobs/{id}/retry 重新执行失败的后台记忆任务
+*/
+
+type Server struct {
+	memSys *memory.MemorySystem
+	mux    *http.ServeMux
+}
+
+func NewServer(memSys *memory.MemorySystem) *Server {
+	s := &Server{
+		memSys: memSys,
+		mux:    http.NewServeMux(),
+	}
+	s.mux.HandleFunc("POST /v1/input", s.handleInput)
+	s.mux.HandleFunc("POST /v1/output", s.handleOutput)
+	s.mux.HandleFunc("GET /v1/memories", s.handleListMemories)
+	s.mux.HandleFunc("DELETE /v1/memories", s.handleDeleteMemories)
+	s.mux.HandleFunc("GET /v1/memories/archived", s.handleListArchivedMemories)
+	s.mux.HandleFunc("GET /v1/memories/{id}/history", s.handleMemoryHistory)
+	s.mux.HandleFunc("POST /v1/memories/{id}/rollback", s.handleRollback)
+	s.mux.HandleFunc("GET /v1/extractions", s.handleListExtractionRuns)
+	s.mux.HandleFunc("POST /v1/extractions/{id}/revert", s.handleRevertExtraction)
+	s.mux.HandleFunc("GET /v1/relations", s.handleListRelations)
+	s.mux.HandleFunc("POST /v1/flush", s.handleFlush)
+	s.mux.HandleFunc("GET /v1/jobs", s.handleListJobs)
+	s.mux.HandleFunc("POST /v1/jobs/{id}/retry", s.handleRetryJob)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// 等待所有正在进行的记忆更新完成
+func (s *Server) WaitDone() {
+	s.memSys.WaitDone()
+}
+
+func (s *Server) handleInput(w http.ResponseWriter, r *http.Request) {
+	var req InputRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := requireSession(req.UserID, req.SessionID); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.Input == "" {
+		writeError(w, http.StatusBadRequest, errors.New("input is required"))
+		return
+	}
+	prompt, usage, err := s.memSys.ProcessInputWithUsageContext(r.Context(), req.UserID, req.SessionID, req.Input)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, InputResponse{Prompt: prompt, Usage: usage})
+}
+
+func (s *Server) handleOutput(w http.ResponseWriter, r *http.Request) {
+	var req OutputRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := requireSession(req.UserID, req.SessionID); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.Output == "" {
+		writeError(w, http.StatusBadRequest, errors.New("output is required"))
+		return
+	}
+	if err := s.memSys.ProcessOutputContext(r.Context(), req.UserID, req.SessionID, req.Output); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, StatusResponse{Status: "ok"})
+}
+
+func (s *Server) handleListMemories(w http.ResponseWriter, r *http.Request) {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		writeError(w, http.StatusBadRequest, errors.New("user_id is required"))
+		return
+	}
+	query := r.URL.Query().Get("query")
+	if query == "" {
+		memories, err := s.memSys.ListLongMemoryContext(r.Context(), userID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, MemoriesResponse{Memories: memories})
+		return
+	}
+	opts, err := parseSearchOptions(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	longMemory, err := s.memSys.SearchLongMemoryContext(r.Context(), userID, query, opts)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, MemoriesResponse{Memories: longMemory.VectorMemorys})
+}
+
+func (s *Server) handleListArchivedMemories(w http.ResponseWriter, r *http.Request) {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		writeError(w, http.StatusBadRequest, errors.New("user_id is required"))
+		return
+	}
+	memories, err := s.memSys.ListArchivedMemoryContext(r.Context(), userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, MemoriesResponse{Memories: memories})
+}
+
+func (s *Server) handleDeleteMemories(w http.ResponseWriter, r *http.Request) {
+	userID := r.URL.Query().Get("user_id")
+	ids := r.URL.Query()["id"]
+	if userID == "" {
+		writeError(w, http.StatusBadRequest, errors.New("user_id is required"))
+		return
+	}
+	if len(ids) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("at least one id is required"))
+		return
+	}
+	if err := s.memSys.DeleteLongMemoryContext(r.Context(), userID, ids...); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, DeleteMemoriesResponse{Deleted: ids})
+}
+
+func (s *Server) handleMemoryHistory(w http.ResponseWriter, r *http.Request) {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		writeError(w, http.StatusBadRequest, errors.New("user_id is required"))
+		return
+	}
+	history, err := s.memSys.HistoryContext(r.Context(), userID, r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, HistoryResponse{History: history})
+}
+
+func (s *Server) handleRollback(w http.ResponseWriter, r *http.Request) {
+	var req RollbackRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.UserID == "" {
+		writeError(w, http.StatusBadRequest, errors.New("user_id is required"))
+		return
+	}
+	if err := s.memSys.RollbackContext(r.Context(), req.UserID, r.PathValue("id"), req.Version); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, StatusResponse{Status: "ok"})
+}
+
+func (s *Server) handleListExtractionRuns(w http.ResponseWriter, r *http.Request) {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		writeError(w, http.StatusBadRequest, errors.New("user_id is required"))
+		return
+	}
+	runs, err := s.memSys.ListExtractionRunsContext(r.Context(), userID, r.URL.Query().Get("session_id"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ExtractionRunsResponse{Runs: runs})
+}
+
+func (s *Server) handleListRelations(w http.ResponseWriter, r *http.Request) {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		writeError(w, http.StatusBadRequest, errors.New("user_id is required"))
+		return
+	}
+	relations, err := s.memSys.ListRelationsContext(r.Context(), userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, RelationsResponse{Relations: relations})
+}
+
+func (s *Server) handleRevertExtraction(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("invalid extraction run id"))
+		return
+	}
+	var req RevertExtractionRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.UserID == "" {
+		writeError(w, http.StatusBadRequest, errors.New("user_id is required"))
+		return
+	}
+	run, err := s.memSys.RevertExtractionContext(r.Context(), req.UserID, id, req.ResetCursor)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, run)
+}
+
+func (s *Server) handleFlush(w http.ResponseWriter, r *http.Request) {
+	var req FlushRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := requireSession(req.UserID, req.SessionID); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.memSys.FlushMemoryContext(r.Context(), req.UserID, req.SessionID); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, StatusResponse{Status: "ok"})
+}
+
+func (s *Server) handleListJobs(w http.ResponseWriter, r *http.Request) {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		writeError(w, http.StatusBadRequest, errors.New("user_id is required"))
+		return
+	}
+	jobs, err := s.memSys.ListJobs(userID, r.URL.Query().Get("status"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, JobsResponse{Jobs: jobs})
+}
+
+func (s *Server) handleRetryJob(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("invalid job id"))
+		return
+	}
+	var req RetryJobRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.UserID == "" {
+		writeError(w, http.StatusBadRequest, errors.New("user_id is required"))
+		return
+	}
+	job, err := s.memSys.RetryJob(req.UserID, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// 解析搜索条件 meta.<key>=<value> 为元数据过滤 contains/not_contains 可以重复
+// after/before 为时间范围 half_life/recency_weight 覆盖时间衰减的配置
+func parseSearchOptions(values url.Values) (memory.SearchOptions, error) {
+	opts := memory.SearchOptions{
+		Contains:    values["contains"],
+		NotContains: values["not_contains"],
+	}
+	for key := range values {
+		if name, ok := strings.CutPrefix(key, "meta."); ok && name != "" {
+			if opts.Where == nil {
+				opts.Where = make(map[string]string)
+			}
+			opts.Where[name] = values.Get(key)
+		}
+	}
+	if v := values.Get("top_k"); v != "" {
+		topK, err := strconv.Atoi(v)
+		if err != nil || topK < 0 {
+			return opts, errors.New("invalid top_k")
+		}
+		opts.TopK = topK
+	}
+	if v := values.Get("threshold"); v != "" {
+		threshold, err := strconv.ParseFloat(v, 32)
+		if err != nil {
+			return opts, errors.New("invalid threshold")
+		}
+		t := float32(threshold)
+		opts.Threshold = &t
+	}
+	var err error
+	if opts.After, err = parseTime(values.Get("after")); err != nil {
+		return opts, errors.New("invalid after")
+	}
+	if opts.Before, err = parseTime(values.Get("before")); err != nil {
+		return opts, errors.New("invalid before")
+	}
+	if v := values.Get("half_life"); v != "" {
+		halfLife, err := time.ParseDuration(v)
+		if err != nil || halfLife < 0 {
+			return opts, errors.New("invalid half_life")
+		}
+		opts.HalfLife = halfLife
+	}
+	if v := values.Get("recency_weight"); v != "" {
+		weight, err := strconv.ParseFloat(v, 32)
+		if err != nil || weight < 0 || weight > 1 {
+			return opts, errors.New("invalid recency_weight")
+		}
+		w := float32(weight)
+		opts.RecencyWeight = &w
+	}
+	return opts, nil
+}
+
+// 解析时间 支持 RFC3339 2006-01-02 15:04:05 和 2006-01-02 为空时返回零值
+func parseTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation(time.DateTime, v, time.Local); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation(time.DateOnly, v, time.Local)
+}
+
+func requireSession(userID, sessionID string) error {
+	if userID == "" {
+		return errors.New("user_id is required")
+	}
+	if sessionID == "" {
+		return errors.New("session_id is required")
+	}
+	return nil
+}
+
+func decodeJSON(r *http.Request, v any) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return errors.New("invalid json body: " + err.Error())
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logrus.Errorf("write response error: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, ErrorResponse{Error: err.Error()})
+}
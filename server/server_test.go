@@ -0,0 +1,202 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/xuanlv2002/miniMem0/config"
+	"github.com/xuanlv2002/miniMem0/llm"
+	"github.com/xuanlv2002/miniMem0/llm/llmtest"
+	"github.com/xuanlv2002/miniMem0/memory"
+	"github.com/xuanlv2002/miniMem0/model"
+)
+
+const (
+	testUser    = "u1"
+	testSession = "s1"
+)
+
+// 按提示词返回总结 事实和记忆操作 failing 为true时模型请求失败
+func newTestServer(t *testing.T, failing *atomic.Bool) (*Server, *httptest.Server) {
+	t.Helper()
+	chat := llmtest.NewScriptedChatModel()
+	chat.Handler = func(messages []openai.ChatCompletionMessage) llmtest.Response {
+		if failing.Load() {
+			return llmtest.Response{Err: errors.New("model unavailable")}
+		}
+		input := messages[len(messages)-1].Content
+		switch {
+		case strings.Contains(input, "#可能相关的记忆"):
+			return llmtest.Response{Content: `{"memory":[{"id":"","text":"我叫小明","event":"ADD"}]}`}
+		case strings.Contains(input, "#待提取信息记忆"):
+			return llmtest.Response{Content: `{"facts":[{"content":"我叫小明","appearTime":"2025-07-26 21:39:30","about":"user","importance":8}]}`}
+		default:
+			return llmtest.Response{Content: "用户叫小明"}
+		}
+	}
+
+	dir := t.TempDir()
+	cfg := &config.Config{
+		EmbeddingConfig: &config.EmbeddingConfig{Provider: llm.EmbeddingProviderLocal},
+		VectorConfig: &config.VectorConfig{
+			Path:       filepath.Join(dir, "vector"),
+			Collection: "test",
+			TopK:       10,
+		},
+		SqlConfig:           &config.SqlConfig{Path: filepath.Join(dir, "memory.db")},
+		MemoryContextConfig: &config.ContextMemoryConfig{SummaryGap: 2},
+		LongMemoryConfig:    &config.LongMemoryConfig{LongGap: 2},
+		ShortMemoryConfig:   &config.ShortMemoryConfig{ShortWindow: 6},
+		PromptBudgetConfig:  &config.PromptBudgetConfig{},
+		PromptConfig:        &config.PromptConfig{Language: "zh"},
+		JobConfig:           &config.JobConfig{Workers: 1, MaxAttempts: 1},
+	}
+	memSys, err := memory.NewMemorySystemWithChatModel(cfg, chat)
+	if err != nil {
+		t.Fatalf("NewMemorySystemWithChatModel: %v", err)
+	}
+	s := NewServer(memSys)
+	srv := httptest.NewServer(s)
+	t.Cleanup(func() {
+		srv.Close()
+		memSys.Close()
+	})
+	return s, srv
+}
+
+// 发送请求 检查状态码 并把响应解析到 out 中
+func call(t *testing.T, srv *httptest.Server, method, path string, body any, wantStatus int, out any) {
+	t.Helper()
+	var reader *bytes.Reader
+	if body == nil {
+		reader = bytes.NewReader(nil)
+	} else {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("json.Marshal: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, srv.URL+path, reader)
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != wantStatus {
+		var e ErrorResponse
+		json.NewDecoder(resp.Body).Decode(&e)
+		t.Fatalf("%s %s = %d %q, want %d", method, path, resp.StatusCode, e.Error, wantStatus)
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("decode %s %s: %v", method, path, err)
+		}
+	}
+}
+
+func TestServer(t *testing.T) {
+	var failing atomic.Bool
+	s, srv := newTestServer(t, &failing)
+	session := map[string]string{"user_id": testUser, "session_id": testSession}
+	userQuery := "?user_id=" + testUser
+
+	// 输入和输出
+	call(t, srv, "POST", "/v1/input", session, http.StatusBadRequest, nil)
+	var input InputResponse
+	call(t, srv, "POST", "/v1/input", map[string]string{"user_id": testUser, "session_id": testSession, "input": "我叫小明"}, http.StatusOK, &input)
+	if !strings.Contains(input.Prompt, "我叫小明") {
+		t.Fatalf("prompt %q misses the input", input.Prompt)
+	}
+
+	// 后台任务失败后 只有任务所属的用户可以查看和重试
+	failing.Store(true)
+	call(t, srv, "POST", "/v1/output", map[string]string{"user_id": testUser, "session_id": testSession, "output": "你好小明"}, http.StatusOK, nil)
+	s.WaitDone()
+	call(t, srv, "GET", "/v1/jobs", nil, http.StatusBadRequest, nil)
+	var jobs JobsResponse
+	call(t, srv, "GET", "/v1/jobs?user_id=other", nil, http.StatusOK, &jobs)
+	if len(jobs.Jobs) != 0 {
+		t.Fatalf("got jobs %+v for another user, want none", jobs.Jobs)
+	}
+	call(t, srv, "GET", "/v1/jobs"+userQuery+"&status="+model.JobStatusFailed, nil, http.StatusOK, &jobs)
+	if len(jobs.Jobs) != 2 {
+		t.Fatalf("got jobs %+v, want the failed summary and extraction", jobs.Jobs)
+	}
+	failing.Store(false)
+	for _, job := range jobs.Jobs {
+		path := fmt.Sprintf("/v1/jobs/%d/retry", job.ID)
+		call(t, srv, "POST", path, map[string]string{}, http.StatusBadRequest, nil)
+		call(t, srv, "POST", path, map[string]string{"user_id": "other"}, http.StatusNotFound, nil)
+		call(t, srv, "POST", path, map[string]string{"user_id": testUser}, http.StatusOK, nil)
+	}
+	call(t, srv, "POST", "/v1/flush", map[string]string{"user_id": testUser}, http.StatusBadRequest, nil)
+	call(t, srv, "POST", "/v1/flush", session, http.StatusOK, nil)
+	call(t, srv, "GET", "/v1/jobs"+userQuery, nil, http.StatusOK, &jobs)
+	if len(jobs.Jobs) != 0 {
+		t.Fatalf("got jobs %+v after retry, want none", jobs.Jobs)
+	}
+
+	// 长期记忆
+	call(t, srv, "GET", "/v1/memories", nil, http.StatusBadRequest, nil)
+	var memories MemoriesResponse
+	call(t, srv, "GET", "/v1/memories"+userQuery, nil, http.StatusOK, &memories)
+	if len(memories.Memories) != 1 || memories.Memories[0].Text != "我叫小明" {
+		t.Fatalf("got memories %+v, want the extracted memory", memories.Memories)
+	}
+	memoryID := memories.Memories[0].ID
+	call(t, srv, "GET", "/v1/memories"+userQuery+"&query=小明&top_k=5", nil, http.StatusOK, &memories)
+	if len(memories.Memories) != 1 {
+		t.Fatalf("got search results %+v, want the memory", memories.Memories)
+	}
+	call(t, srv, "GET", "/v1/memories"+userQuery+"&query=小明&top_k=x", nil, http.StatusBadRequest, nil)
+	call(t, srv, "GET", "/v1/memories/archived"+userQuery, nil, http.StatusOK, &memories)
+	if len(memories.Memories) != 0 {
+		t.Fatalf("got archived memories %+v, want none", memories.Memories)
+	}
+	call(t, srv, "GET", "/v1/relations"+userQuery, nil, http.StatusOK, &RelationsResponse{})
+
+	// 删除后通过变更记录回滚
+	call(t, srv, "DELETE", "/v1/memories"+userQuery, nil, http.StatusBadRequest, nil)
+	var deleted DeleteMemoriesResponse
+	call(t, srv, "DELETE", "/v1/memories"+userQuery+"&id="+memoryID, nil, http.StatusOK, &deleted)
+	if len(deleted.Deleted) != 1 || deleted.Deleted[0] != memoryID {
+		t.Fatalf("got deleted %v, want %s", deleted.Deleted, memoryID)
+	}
+	var history HistoryResponse
+	call(t, srv, "GET", "/v1/memories/"+memoryID+"/history"+userQuery, nil, http.StatusOK, &history)
+	if len(history.History) != 2 || history.History[1].Event != "DELETE" {
+		t.Fatalf("got history %+v, want ADD then DELETE", history.History)
+	}
+	call(t, srv, "POST", "/v1/memories/"+memoryID+"/rollback", map[string]any{"user_id": testUser, "version": 1}, http.StatusOK, nil)
+	call(t, srv, "GET", "/v1/memories"+userQuery, nil, http.StatusOK, &memories)
+	if len(memories.Memories) != 1 {
+		t.Fatalf("got memories %+v after rollback, want the restored memory", memories.Memories)
+	}
+
+	// 撤销抽取
+	var runs ExtractionRunsResponse
+	call(t, srv, "GET", "/v1/extractions"+userQuery+"&session_id="+testSession, nil, http.StatusOK, &runs)
+	if len(runs.Runs) != 1 {
+		t.Fatalf("got runs %+v, want one", runs.Runs)
+	}
+	revertPath := fmt.Sprintf("/v1/extractions/%d/revert", runs.Runs[0].ID)
+	call(t, srv, "POST", revertPath, map[string]any{"user_id": "other"}, http.StatusNotFound, nil)
+	call(t, srv, "POST", revertPath, map[string]any{"user_id": testUser}, http.StatusOK, nil)
+	call(t, srv, "GET", "/v1/memories"+userQuery, nil, http.StatusOK, &memories)
+	if len(memories.Memories) != 0 {
+		t.Fatalf("got memories %+v after revert, want none", memories.Memories)
+	}
+}
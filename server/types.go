@@ -0,0 +1,90 @@
+package server
+
+import "github.com/xuanlv2002/miniMem0/model"
+
+/*
+	HTTP接口的请求与响应结构
+*/
+
+// POST /v1/input 请求
+type InputRequest struct {
+	UserID    string `json:"user_id"`
+	SessionID string `json:"session_id"`
+	Input     string `json:"input"`
+}
+
+// POST /v1/input 响应
+type InputResponse struct {
+	Prompt string            `json:"prompt"` // 带有记忆的提示词
+	Usage  *model.TokenUsage `json:"usage"`  // 提示词各部分的token用量
+}
+
+// POST /v1/output 请求
+type OutputRequest struct {
+	UserID    string `json:"user_id"`
+	SessionID string `json:"session_id"`
+	Output    string `json:"output"`
+}
+
+// POST /v1/flush 请求
+type FlushRequest struct {
+	UserID    string `json:"user_id"`
+	SessionID string `json:"session_id"`
+}
+
+// GET /v1/memories 和 GET /v1/memories/archived 响应
+type MemoriesResponse struct {
+	Memories []model.LongMemoryItem `json:"memories"`
+}
+
+// DELETE /v1/memories 响应
+type DeleteMemoriesResponse struct {
+	Deleted []string `json:"deleted"`
+}
+
+// GET /v1/memories/{id}/history 响应
+type HistoryResponse struct {
+	History []model.MemoryHistory `json:"history"`
+}
+
+// POST /v1/memories/{id}/rollback 请求
+type RollbackRequest struct {
+	UserID  string `json:"user_id"`
+	Version int    `json:"version"` // 回滚到的版本
+}
+
+// GET /v1/extractions 响应
+type ExtractionRunsResponse struct {
+	Runs []model.ExtractionRun `json:"runs"`
+}
+
+// GET /v1/relations 响应
+type RelationsResponse struct {
+	Relations []model.Relation `json:"relations"`
+}
+
+// POST /v1/extractions/{id}/revert 请求
+type RevertExtractionRequest struct {
+	UserID      string `json:"user_id"`
+	ResetCursor bool   `json:"reset_cursor"` // 是否把会话的抽取进度退回到抽取前
+}
+
+// POST /v1/jobs/{id}/retry 请求 只能重试属于该用户的任务
+type RetryJobRequest struct {
+	UserID string `json:"user_id"`
+}
+
+// GET /v1/jobs 响应
+type JobsResponse struct {
+	Jobs []model.MemoryJob `json:"jobs"`
+}
+
+// 无返回内容的接口响应
+type StatusResponse struct {
+	Status string `json:"status"`
+}
+
+// 错误响应
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
@@ -0,0 +1,53 @@
+package tokenizer
+
+import (
+	"strings"
+	"unicode"
+)
+
+/*
+	关键词检索使用的分词
+	中日韩文字没有空格分隔 按相邻两个字切分(单独一个字时保留单字)
+	连续的字母数字作为一个词 统一转为小写 其他符号作为分隔
+*/
+
+// 把文本切分为关键词 结果可能重复 重复次数即词频
+func Keywords(text string) []string {
+	var tokens []string
+	var word, cjk []rune
+	flushWord := func() {
+		if len(word) > 0 {
+			tokens = append(tokens, string(word))
+			word = word[:0]
+		}
+	}
+	flushCJK := func() {
+		switch len(cjk) {
+		case 0:
+			return
+		case 1:
+			tokens = append(tokens, string(cjk))
+		default:
+			for i := 0; i+1 < len(cjk); i++ {
+				tokens = append(tokens, string(cjk[i:i+2]))
+			}
+		}
+		cjk = cjk[:0]
+	}
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case IsCJK(r):
+			flushWord()
+			cjk = append(cjk, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			flushCJK()
+			word = append(word, r)
+		default:
+			flushWord()
+			flushCJK()
+		}
+	}
+	flushWord()
+	flushCJK()
+	return tokens
+}
@@ -0,0 +1,56 @@
+package tokenizer
+
+import (
+	"unicode"
+)
+
+/*
+	用于估算提示词的token数量
+	不同模型的分词方式不同 可以通过实现 Tokenizer 接入模型对应的分词器
+*/
+
+type Tokenizer interface {
+	Count(text string) int
+}
+
+// 基于字符的启发式估算 不依赖任何词表
+// 中日韩文字每个字符计1个token 连续的字母数字每4个字符计1个token 其他可见符号每个计1个token 空白不计
+type HeuristicTokenizer struct{}
+
+var _ Tokenizer = HeuristicTokenizer{}
+
+// 英文单词平均约4个字符一个token
+const charsPerToken = 4
+
+func (HeuristicTokenizer) Count(text string) int {
+	count := 0
+	word := 0
+	flushWord := func() {
+		if word > 0 {
+			count += (word + charsPerToken - 1) / charsPerToken
+			word = 0
+		}
+	}
+	for _, r := range text {
+		switch {
+		case IsCJK(r):
+			flushWord()
+			count++
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			word++
+		case unicode.IsSpace(r):
+			flushWord()
+		default:
+			flushWord()
+			count++
+		}
+	}
+	flushWord()
+	return count
+}
+
+// 是否是中日韩文字
+func IsCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) ||
+		unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r)
+}